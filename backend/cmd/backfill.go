@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"stock-market-websocket/internal/backfill"
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// runBackfill imports historical candles from CSV files or the Finnhub REST
+// API and prints what was imported, or with -dry-run what would be
+func runBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	symbolList := flags.String("symbols", "", "comma separated symbols, defaulting to the tracked symbols or those in the files")
+	fromFlag := flags.String("from", "", "start of the range, as RFC 3339 or a date in the exchange time zone (required)")
+	toFlag := flags.String("to", "", "end of the range, exclusive, defaulting to now")
+	sourceName := flags.String("source", "finnhub", "where candles come from: finnhub or csv")
+	files := flags.String("file", "", "comma separated CSV files, implying -source csv")
+	intervalName := flags.String("interval", string(models.Interval1m), "interval of the imported candles")
+	overwrite := flags.Bool("overwrite", false, "replace stored candles instead of keeping them")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without changing anything")
+	flags.Parse(args)
+
+	cfg := config.LoadCommand()
+	if cfg.STORE == config.StoreMemory {
+		log.Fatalf("The backfill command needs a database store")
+	}
+
+	interval, err := models.ParseInterval(*intervalName)
+	if err != nil {
+		log.Fatalf("Invalid -interval: %v", err)
+	}
+	from, err := parseBackfillTime(*fromFlag, cfg.ExchangeLocation())
+	if err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = parseBackfillTime(*toFlag, cfg.ExchangeLocation()); err != nil {
+			log.Fatalf("Invalid -to: %v", err)
+		}
+	}
+	if !from.Before(to) {
+		log.Fatalf("-from must be before -to")
+	}
+
+	opts := backfill.Options{
+		Interval:  interval,
+		From:      from,
+		To:        to,
+		Overwrite: *overwrite,
+		DryRun:    *dryRun,
+	}
+	if *symbolList != "" {
+		opts.Symbols = strings.Split(*symbolList, ",")
+	}
+
+	var source backfill.Source
+	switch {
+	case *files != "" || *sourceName == "csv":
+		if *files == "" {
+			log.Fatalf("-file is required for the csv source")
+		}
+		csvSource, err := backfill.NewCSVSource(cfg, strings.Split(*files, ",")...)
+		if err != nil {
+			log.Fatalf("Failed to read CSV files: %v", err)
+		}
+		if opts.Symbols == nil {
+			opts.Symbols = csvSource.Symbols()
+		}
+		source = csvSource
+	case *sourceName == "finnhub":
+		if cfg.API_KEY == "" {
+			log.Fatalf("API_KEY environment variable is required for the finnhub source")
+		}
+		if opts.Symbols == nil {
+			opts.Symbols = symbols
+		}
+		source = backfill.NewFinnhubSource(cfg)
+	default:
+		log.Fatalf("Unknown -source %q, expected finnhub or csv", *sourceName)
+	}
+
+	_, candleStore := openStore(cfg)
+	reports, err := backfill.New(cfg, candleStore, source).Run(opts)
+	printBackfillReports(os.Stdout, reports, *dryRun)
+	if err != nil {
+		log.Fatalf("Failed to backfill: %v", err)
+	}
+}
+
+// parseBackfillTime parses an RFC 3339 time or a date, which starts at
+// midnight in the exchange time zone
+func parseBackfillTime(value string, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("a time is required")
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, location)
+}
+
+// printBackfillReports writes one line per symbol
+func printBackfillReports(w io.Writer, reports []backfill.Report, dryRun bool) {
+	imported, rolledUp := "IMPORTED", "ROLLED UP"
+	if dryRun {
+		imported, rolledUp = "WOULD IMPORT", "WOULD ROLL UP"
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "SYMBOL\tFETCHED\tINVALID\tDUPLICATES\tEXISTING\t%s\t%s\n", imported, rolledUp)
+	for _, report := range reports {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", report.Symbol, report.Fetched, report.Invalid, report.Duplicates, report.Existing, report.Imported, report.RolledUp)
+	}
+	table.Flush()
+}
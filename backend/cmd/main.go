@@ -1,18 +1,31 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/backplane"
 	"stock-market-websocket/internal/broadcaster"
 	"stock-market-websocket/internal/config"
 	"stock-market-websocket/internal/database"
 	"stock-market-websocket/internal/handlers"
+	"stock-market-websocket/internal/leader"
 	"stock-market-websocket/internal/middleware"
-	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/recorder"
+	"stock-market-websocket/internal/retention"
 	"stock-market-websocket/internal/services"
+	"stock-market-websocket/internal/source"
+	"stock-market-websocket/internal/sse"
+	"stock-market-websocket/internal/store"
 	"stock-market-websocket/internal/websocket"
 )
 
@@ -21,57 +34,251 @@ var (
 )
 
 func main() {
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	// Load configuration
 	cfg := config.Load()
 
-	// Connect to database
-	db := database.Connect(cfg)
+	// Connect to the candle store
+	db, candleStore := openStore(cfg)
 
 	// Initialize services
-	candleService := services.NewCandleService(db)
-	clientManager := websocket.NewClientManager()
-	broadcaster := broadcaster.NewBroadcaster(clientManager)
+	candleService := services.NewCandleService(candleStore, cfg)
+	clientManager := websocket.NewClientManager(cfg, symbols, candleService)
+	streamHub := sse.NewHub(cfg, symbols, candleService)
+	broadcaster := broadcaster.NewBroadcaster(cfg.BROADCAST_INTERVAL, clientManager, streamHub)
+
+	// Connect to the backplane shared by ingest and edge nodes
+	bp, err := backplane.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create backplane: %v", err)
+	}
 
 	// Start broadcaster
 	broadcaster.Start()
 
-	// Connect candle service to broadcaster
+	// Send candle updates to local clients and, from the node ingesting
+	// trades, to the backplane
+	var (
+		ingesting atomic.Bool
+		publisher *backplane.Publisher
+	)
+	if bp != nil {
+		publisher = backplane.NewPublisher(bp)
+	}
 	go func() {
 		for msg := range candleService.GetBroadcastChannel() {
-			broadcaster.GetBroadcastChannel() <- msg
+			if cfg.ServesClients() {
+				broadcaster.GetBroadcastChannel() <- msg
+			}
+			if publisher != nil && ingesting.Load() {
+				publisher.Publish(msg)
+			}
 		}
 	}()
 
-	// Initialize Finnhub client with candle service integration
-	finnhubClient := websocket.NewFinnhubClient(
-		cfg,
-		db,
-		symbols,
-		candleService,
-		func(msg *models.BroadcastMessage) {
-			// This callback can be used for additional processing if needed
-			log.Printf("Broadcast message received for symbol: %s", msg.Candle.Symbol)
-		},
+	// Enforce the optional retention policy on the ingesting node
+	retentionEngine, err := retention.New(cfg, candleStore)
+	if err != nil {
+		log.Fatalf("Failed to create retention engine: %v", err)
+	}
+
+	var (
+		tradeSource  source.TradeSource
+		tickRecorder recorder.Recorder
 	)
+	if cfg.IngestsTrades() {
+		tradeSource, tickRecorder = newIngest(cfg, db, candleService)
+		startIngesting := func() {
+			ingesting.Store(true)
+			candleService.Start()
+			if retentionEngine != nil {
+				retentionEngine.Start()
+			}
+			if err := tradeSource.Connect(); err != nil {
+				log.Printf("Failed to connect to %s: %v", tradeSource.Name(), err)
+			}
+			tradeSource.Start()
+		}
+
+		if cfg.ElectsLeader() {
+			// Followers serve reads, and updates from the backplane if there is
+			// one, until they are elected
+			followCtx, stopFollowing := context.WithCancel(context.Background())
+			var followed <-chan struct{}
+			if bp != nil {
+				followed = followBackplane(followCtx, bp, candleService)
+			}
+			go newElector(cfg, db).Run(context.Background(), func() {
+				// Drop the mirrored sequence numbers once no more updates from
+				// the previous leader are applied
+				stopFollowing()
+				if followed != nil {
+					<-followed
+				}
+				candleService.Promote()
+				startIngesting()
+			}, func() {
+				// Exit rather than risk two nodes ingesting; a restart rejoins
+				// as a follower
+				log.Printf("Lost leadership, exiting")
+				shutdown(tradeSource, candleService, retentionEngine, tickRecorder, publisher, bp)
+				os.Exit(1)
+			})
+		} else {
+			startIngesting()
+		}
+	} else {
+		// Edge nodes take every update from the ingest node
+		followBackplane(context.Background(), bp, candleService)
+	}
 
-	// Start Finnhub client
-	finnhubClient.Connect()
-	finnhubClient.Start()
+	// Flush recorded trades and candles and close the backplane on shutdown
+	go handleShutdown(tradeSource, candleService, retentionEngine, tickRecorder, publisher, bp)
 
-	// Initialize handlers (after Finnhub client is created)
-	handler := handlers.NewHandler(candleService, symbols, finnhubClient, clientManager)
+	// Initialize handlers (after trade source is created)
+	handler := handlers.NewHandler(candleService, symbols, tradeSource, clientManager, streamHub)
 
 	// Keep-alive mechanism to prevent Render from sleeping
 	go keepAlivePing(cfg.SERVER_PORT)
 
 	// Setup routes
-	setupRoutes(handler, clientManager)
+	setupRoutes(handler, clientManager, streamHub, cfg.ServesClients())
 
 	// Start server
 	log.Printf("Server is running on port %s", cfg.SERVER_PORT)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", cfg.SERVER_PORT), nil))
 }
 
+// runCommand runs a maintenance command
+func runCommand(name string, args []string) {
+	switch name {
+	case "migrate":
+		runMigrate(args)
+	case "retention":
+		runRetention(args)
+	case "backfill":
+		runBackfill(args)
+	default:
+		log.Fatalf("Unknown command %q, expected migrate, retention or backfill", name)
+	}
+}
+
+// openStore connects to the database, unless candles are kept in memory,
+// and creates the candle store on it
+func openStore(cfg *config.Env) (*gorm.DB, store.CandleStore) {
+	var db *gorm.DB
+	if cfg.STORE != config.StoreMemory {
+		db = database.Connect(cfg)
+	}
+	candleStore, err := store.New(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to create candle store: %v", err)
+	}
+	log.Printf("Storing candles in %s", candleStore.Name())
+	return db, candleStore
+}
+
+// newIngest creates the configured market data source and feeds its trades
+// into the optional tick recorder and the candle service once it is started
+func newIngest(cfg *config.Env, db *gorm.DB, candleService *services.CandleService) (source.TradeSource, recorder.Recorder) {
+	// Initialize the configured market data source
+	tradeSource, err := source.New(cfg, symbols)
+	if err != nil {
+		log.Fatalf("Failed to create trade source: %v", err)
+	}
+
+	// Initialize optional raw trade recording
+	tickRecorder, err := recorder.New(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to create tick recorder: %v", err)
+	}
+
+	// Feed trades from the source into the recorder and candle service
+	go func() {
+		for trade := range tradeSource.Trades() {
+			if tickRecorder != nil {
+				tickRecorder.Record(trade)
+			}
+			candleService.ProcessTradeData(&trade)
+		}
+	}()
+
+	return tradeSource, tickRecorder
+}
+
+// newElector creates the elector deciding which replica ingests trades
+func newElector(cfg *config.Env, db *gorm.DB) *leader.Elector {
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("Failed to get database handle for leader election: %v", err)
+	}
+	return leader.NewElector(leader.NewAdvisoryLock(sqlDB, cfg.LEADER_LOCK_KEY), cfg.LEADER_RETRY_INTERVAL)
+}
+
+// followBackplane applies the updates published by the ingesting node until
+// ctx is done. The returned channel is closed once no more updates are
+// applied.
+func followBackplane(ctx context.Context, bp backplane.Backplane, candleService *services.CandleService) <-chan struct{} {
+	updates, err := bp.Subscribe(ctx)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to %s backplane: %v", bp.Name(), err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range updates {
+			// Updates still buffered when ctx is done are dropped
+			if ctx.Err() != nil {
+				return
+			}
+			candleService.ApplyRemote(msg)
+		}
+	}()
+	log.Printf("Serving updates from the %s backplane", bp.Name())
+	return done
+}
+
+// handleShutdown shuts down when the process is interrupted
+func handleShutdown(tradeSource source.TradeSource, candleService *services.CandleService, retentionEngine *retention.Engine, tickRecorder recorder.Recorder, publisher *backplane.Publisher, bp backplane.Backplane) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	log.Printf("Shutting down")
+	shutdown(tradeSource, candleService, retentionEngine, tickRecorder, publisher, bp)
+	os.Exit(0)
+}
+
+// shutdown stops the trade source, lets a retention run in progress finish,
+// writes queued candles and closes the tick recorder and backplane
+func shutdown(tradeSource source.TradeSource, candleService *services.CandleService, retentionEngine *retention.Engine, tickRecorder recorder.Recorder, publisher *backplane.Publisher, bp backplane.Backplane) {
+	// Trades already read from the source are still processed; candles
+	// closed by them after the writer stops are spilled for the next run
+	if tradeSource != nil {
+		tradeSource.Stop()
+	}
+	if retentionEngine != nil {
+		retentionEngine.Stop()
+	}
+	candleService.Stop()
+	if tickRecorder != nil {
+		if err := tickRecorder.Close(); err != nil {
+			log.Printf("Failed to close tick recorder: %v", err)
+		}
+	}
+	if bp != nil {
+		publisher.Close()
+		if err := bp.Close(); err != nil {
+			log.Printf("Failed to close backplane: %v", err)
+		}
+	}
+}
+
 // keepAlivePing pings the server to keep it alive
 func keepAlivePing(port string) {
 	ticker := time.NewTicker(10 * time.Minute) // Ping every 10 minutes
@@ -95,7 +302,7 @@ func keepAlivePing(port string) {
 }
 
 // setupRoutes configures all HTTP routes
-func setupRoutes(handler *handlers.Handler, clientManager *websocket.ClientManager) {
+func setupRoutes(handler *handlers.Handler, clientManager *websocket.ClientManager, streamHub *sse.Hub, servesClients bool) {
 	// Health check endpoint
 	http.HandleFunc("/health", handler.HandleHealth)
 
@@ -105,8 +312,13 @@ func setupRoutes(handler *handlers.Handler, clientManager *websocket.ClientManag
 	// Connection status endpoint
 	http.HandleFunc("/status", handler.HandleStatus)
 
-	// Connect to WebSocket
-	http.HandleFunc("/ws", middleware.CORS(clientManager.HandleWebSocket))
+	if servesClients {
+		// Connect to WebSocket
+		http.HandleFunc("/ws", middleware.CORS(clientManager.HandleWebSocket))
+
+		// Stream updates as Server-Sent Events where WebSocket is unavailable
+		http.HandleFunc("/stream", middleware.CORS(streamHub.HandleStream))
+	}
 
 	// Get available symbols
 	http.HandleFunc("/symbols", middleware.CORS(handler.HandleGetSymbols))
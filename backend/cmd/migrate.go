@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/database"
+)
+
+// runMigrate applies, reverts or lists schema migrations:
+//
+//	migrate [up]
+//	migrate down [-steps N]
+//	migrate status
+func runMigrate(args []string) {
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	switch action {
+	case "up", "down", "status":
+	default:
+		log.Fatalf("Unknown migrate action %q, usage: migrate [up | down [-steps N] | status]", action)
+	}
+	flags := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert with down")
+	flags.Parse(args)
+
+	cfg := config.LoadCommand()
+	if cfg.STORE == config.StoreMemory {
+		log.Fatalf("The migrate command needs a database store")
+	}
+	db, err := database.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	switch action {
+	case "up":
+		applied, err := database.MigrateUp(db)
+		if err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+		log.Printf("Applied %d migrations", applied)
+	case "down":
+		if *steps <= 0 {
+			log.Fatalf("-steps must be positive")
+		}
+		reverted, err := database.MigrateDown(db, *steps)
+		if err != nil {
+			log.Fatalf("Failed to revert migrations: %v", err)
+		}
+		log.Printf("Reverted %d migrations", reverted)
+	case "status":
+		statuses, err := database.Status(db)
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+		printMigrationStatus(os.Stdout, statuses)
+	}
+}
+
+// printMigrationStatus writes one line per migration
+func printMigrationStatus(w io.Writer, statuses []database.MigrationStatus) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(table, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	table.Flush()
+}
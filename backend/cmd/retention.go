@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/retention"
+)
+
+// runRetention enforces RETENTION once and prints what was deleted, or with
+// -dry-run what would be deleted
+func runRetention(args []string) {
+	flags := flag.NewFlagSet("retention", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be deleted without changing anything")
+	flags.Parse(args)
+
+	cfg := config.LoadCommand()
+	if cfg.STORE == config.StoreMemory {
+		log.Fatalf("The retention command needs a database store")
+	}
+	_, candleStore := openStore(cfg)
+
+	engine, err := retention.New(cfg, candleStore)
+	if err != nil {
+		log.Fatalf("Failed to create retention engine: %v", err)
+	}
+	if engine == nil {
+		log.Fatalf("RETENTION is not set, nothing to do")
+	}
+
+	reports, err := engine.Run(time.Now(), *dryRun)
+	printRetentionReports(os.Stdout, reports, *dryRun)
+	if err != nil {
+		log.Fatalf("Failed to enforce retention: %v", err)
+	}
+}
+
+// printRetentionReports writes one line per interval
+func printRetentionReports(w io.Writer, reports []retention.Report, dryRun bool) {
+	expired, rolledUp := "DELETED", "CREATED"
+	if dryRun {
+		expired, rolledUp = "WOULD DELETE", "WOULD CREATE"
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "INTERVAL\tBEFORE\t%s\tROLLUP\t%s\n", expired, rolledUp)
+	for _, report := range reports {
+		rollup := string(report.RollupInterval)
+		if rollup == "" {
+			rollup = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%d\n", report.Interval, report.Cutoff.Format(time.RFC3339), report.Expired, rollup, report.RolledUp)
+	}
+	table.Flush()
+}
@@ -8,6 +8,8 @@ import (
 	"gorm.io/gorm/logger"
 
 	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/database"
 )
 
 func DBConneciton(env *Env) *gorm.DB {
@@ -19,7 +21,9 @@ func DBConneciton(env *Env) *gorm.DB {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	fmt.Println("Connected to database")
-	if err := db.AutoMigrate(&Candle{}); err != nil {
+	// Share the versioned migrations of ./cmd so that both binaries agree
+	// on the schema
+	if _, err := database.MigrateUp(db); err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 	return db
@@ -0,0 +1,282 @@
+package backfill
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/store"
+)
+
+// writeBatchSize is the number of candles upserted at once
+const writeBatchSize = 500
+
+// Source supplies historical candles
+type Source interface {
+	// Name identifies the implementation
+	Name() string
+	// Candles returns the candles of a symbol and interval from from
+	// (inclusive) to to (exclusive) in any order. Candles outside the range
+	// are ignored.
+	Candles(symbol string, interval models.Interval, from, to time.Time) ([]models.Candle, error)
+}
+
+// Options selects the candles to backfill
+type Options struct {
+	Symbols  []string
+	Interval models.Interval
+	From     time.Time
+	To       time.Time
+	// Replace stored candles rather than keeping them
+	Overwrite bool
+	// Report what would be written without writing
+	DryRun bool
+}
+
+// Report counts what a backfill did for one symbol
+type Report struct {
+	Symbol string `json:"symbol"`
+	// Candles returned by the source within the range
+	Fetched int `json:"fetched"`
+	// Candles rejected by validation
+	Invalid int `json:"invalid"`
+	// Candles repeating an earlier candle of the same bucket
+	Duplicates int `json:"duplicates"`
+	// Candles skipped because the bucket is already stored
+	Existing int `json:"existing"`
+	// Candles written
+	Imported int `json:"imported"`
+	// Coarser candles rebuilt from the imported ones
+	RolledUp int `json:"rolled_up"`
+}
+
+// Backfiller imports historical candles into the candle store, validating
+// them and skipping buckets that are already stored. Coarser intervals the
+// service builds are rebuilt for the buckets the imported candles touch.
+type Backfiller struct {
+	store     store.CandleStore
+	source    Source
+	location  *time.Location
+	intervals []models.Interval
+}
+
+// New creates a backfiller writing to candleStore
+func New(cfg *config.Env, candleStore store.CandleStore, source Source) *Backfiller {
+	var intervals []models.Interval
+	for _, name := range cfg.CANDLE_INTERVALS {
+		if interval, err := models.ParseInterval(name); err == nil && !slices.Contains(intervals, interval) {
+			intervals = append(intervals, interval)
+		}
+	}
+	slices.SortFunc(intervals, func(a, b models.Interval) int {
+		return cmp.Compare(a.Duration(), b.Duration())
+	})
+
+	return &Backfiller{
+		store:     candleStore,
+		source:    source,
+		location:  cfg.ExchangeLocation(),
+		intervals: intervals,
+	}
+}
+
+// Run backfills every symbol in turn. It stops at the first error and
+// returns the reports of the symbols done so far.
+func (b *Backfiller) Run(opts Options) ([]Report, error) {
+	if b.aggregates() && opts.Interval != models.Interval1m {
+		return nil, fmt.Errorf("%s candles are derived from 1m candles by the %s store, backfill 1m instead", opts.Interval, b.store.Name())
+	}
+
+	var reports []Report
+	for _, symbol := range opts.Symbols {
+		report, err := b.backfill(symbol, opts)
+		reports = append(reports, report)
+		if err != nil {
+			return reports, fmt.Errorf("%s: %w", symbol, err)
+		}
+	}
+
+	if b.aggregates() && !opts.DryRun {
+		if err := b.store.(store.AggregateStore).RefreshAggregates(opts.From, opts.To); err != nil {
+			return reports, fmt.Errorf("failed to refresh aggregates: %w", err)
+		}
+	}
+	return reports, nil
+}
+
+// backfill imports the candles of one symbol
+func (b *Backfiller) backfill(symbol string, opts Options) (Report, error) {
+	report := Report{Symbol: symbol}
+
+	candles, err := b.source.Candles(symbol, opts.Interval, opts.From, opts.To)
+	if err != nil {
+		return report, fmt.Errorf("failed to fetch from %s: %w", b.source.Name(), err)
+	}
+
+	// Validate and keep the last candle of every bucket
+	byBucket := make(map[int64]*models.Candle, len(candles))
+	for i := range candles {
+		candle := &candles[i]
+		candle.ID = 0
+		candle.Symbol = symbol
+		candle.Interval = opts.Interval
+		if candle.Timestamp.Before(opts.From) || !candle.Timestamp.Before(opts.To) {
+			continue
+		}
+		report.Fetched++
+
+		if err := b.validate(candle); err != nil {
+			log.Printf("Skipping invalid %s candle of %s at %s: %v", candle.Interval, symbol, candle.Timestamp.Format(time.RFC3339), err)
+			report.Invalid++
+			continue
+		}
+		bucket := candle.Timestamp.UnixNano()
+		if _, ok := byBucket[bucket]; ok {
+			report.Duplicates++
+		}
+		byBucket[bucket] = candle
+	}
+
+	if !opts.Overwrite {
+		query := models.HistoryQuery{Symbols: []string{symbol}, Interval: opts.Interval, From: opts.From, To: opts.To}
+		err := b.store.Stream(query, func(candle *models.Candle) error {
+			if _, ok := byBucket[candle.Timestamp.UnixNano()]; ok {
+				delete(byBucket, candle.Timestamp.UnixNano())
+				report.Existing++
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to read stored candles: %w", err)
+		}
+	}
+
+	imports := make([]*models.Candle, 0, len(byBucket))
+	for _, candle := range byBucket {
+		imports = append(imports, candle)
+	}
+	slices.SortFunc(imports, func(a, c *models.Candle) int {
+		return a.Timestamp.Compare(c.Timestamp)
+	})
+	report.Imported = len(imports)
+	if len(imports) == 0 {
+		return report, nil
+	}
+
+	if !opts.DryRun {
+		if err := b.write(imports); err != nil {
+			return report, err
+		}
+	}
+	if !b.aggregates() {
+		report.RolledUp, err = b.rollUp(symbol, opts.Interval, imports, opts.DryRun)
+	}
+	return report, err
+}
+
+// aggregates reports whether the store derives the coarser intervals itself
+func (b *Backfiller) aggregates() bool {
+	aggregateStore, ok := b.store.(store.AggregateStore)
+	return ok && aggregateStore.Aggregates()
+}
+
+// validate checks that a candle starts a bucket and has consistent prices
+func (b *Backfiller) validate(candle *models.Candle) error {
+	switch {
+	case !candle.Interval.BucketStart(candle.Timestamp, b.location).Equal(candle.Timestamp):
+		return fmt.Errorf("not the start of a %s bucket", candle.Interval)
+	case candle.Open <= 0 || candle.Close <= 0 || candle.Low <= 0:
+		return fmt.Errorf("prices must be positive")
+	case candle.High < max(candle.Open, candle.Close) || candle.Low > min(candle.Open, candle.Close):
+		return fmt.Errorf("high and low must bound open and close")
+	case candle.Volume < 0:
+		return fmt.Errorf("volume must not be negative")
+	}
+	return nil
+}
+
+// rollUp rebuilds the candles of every coarser interval for the buckets the
+// imported candles fall in, streaming all stored candles of those buckets and
+// writing the rebuilt candles in batches. With dryRun it only counts the
+// buckets.
+func (b *Backfiller) rollUp(symbol string, interval models.Interval, imported []*models.Candle, dryRun bool) (int, error) {
+	rolledUp := 0
+	for _, coarse := range b.intervals {
+		if coarse.Duration() <= interval.Duration() {
+			continue
+		}
+
+		touched := make(map[int64]bool)
+		for _, candle := range imported {
+			touched[coarse.BucketStart(candle.Timestamp, b.location).UnixNano()] = true
+		}
+		if dryRun {
+			rolledUp += len(touched)
+			continue
+		}
+		first := coarse.BucketStart(imported[0].Timestamp, b.location)
+		last := coarse.BucketStart(imported[len(imported)-1].Timestamp, b.location)
+
+		var (
+			batch   []*models.Candle
+			current *models.TempCandle
+		)
+		flush := func() error {
+			if current != nil {
+				batch = append(batch, current.ToCandle())
+				rolledUp++
+				current = nil
+			}
+			if len(batch) < writeBatchSize {
+				return nil
+			}
+			err := b.write(batch)
+			batch = batch[:0]
+			return err
+		}
+
+		var writeErr error
+		query := models.HistoryQuery{Symbols: []string{symbol}, Interval: interval, From: first, To: coarse.BucketEnd(last)}
+		err := b.store.Stream(query, func(candle *models.Candle) error {
+			bucket := coarse.BucketStart(candle.Timestamp, b.location)
+			if !touched[bucket.UnixNano()] {
+				return nil
+			}
+			if current == nil || !current.OpenTime.Equal(bucket) {
+				if writeErr = flush(); writeErr != nil {
+					return writeErr
+				}
+				current = models.NewRollup(symbol, coarse, bucket)
+			}
+			current.Merge(candle)
+			return nil
+		})
+		if writeErr != nil {
+			return rolledUp, writeErr
+		}
+		if err != nil {
+			return rolledUp, fmt.Errorf("failed to read %s candles: %w", interval, err)
+		}
+		if err := flush(); err != nil {
+			return rolledUp, err
+		}
+		if err := b.write(batch); err != nil {
+			return rolledUp, err
+		}
+	}
+	return rolledUp, nil
+}
+
+// write upserts candles in batches
+func (b *Backfiller) write(candles []*models.Candle) error {
+	for batch := range slices.Chunk(candles, writeBatchSize) {
+		if err := b.store.Upsert(batch); err != nil {
+			return fmt.Errorf("failed to write candles: %w", err)
+		}
+	}
+	return nil
+}
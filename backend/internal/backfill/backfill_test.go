@@ -0,0 +1,290 @@
+package backfill
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/store"
+)
+
+// fakeSource returns fixed candles
+type fakeSource struct {
+	candles []models.Candle
+}
+
+func (f *fakeSource) Name() string { return "fake" }
+
+func (f *fakeSource) Candles(symbol string, interval models.Interval, from, to time.Time) ([]models.Candle, error) {
+	return slices.Clone(f.candles), nil
+}
+
+func newTestConfig(timezone string) *config.Env {
+	return &config.Env{
+		EXCHANGE_TIMEZONE: timezone,
+		CANDLE_INTERVALS:  []string{"1m", "5m"},
+		FINNHUB_REST_URL:  "http://localhost",
+		API_KEY:           "secret",
+	}
+}
+
+func minuteCandle(at time.Time, price float64) models.Candle {
+	return models.Candle{Timestamp: at, Open: price, High: price + 1, Low: price - 1, Close: price, Volume: 10}
+}
+
+func TestBackfiller_SkipsStoredDuplicateAndInvalidCandles(t *testing.T) {
+	from := time.Date(2024, 6, 10, 14, 0, 0, 0, time.UTC)
+	to := from.Add(5 * time.Minute)
+
+	candleStore := store.NewMemory()
+	stored := minuteCandle(from.Add(time.Minute), 500)
+	stored.Symbol, stored.Interval = "AAPL", models.Interval1m
+	candleStore.Upsert([]*models.Candle{&stored})
+
+	invalid := minuteCandle(from.Add(3*time.Minute), 100)
+	invalid.High = 50
+	source := &fakeSource{candles: []models.Candle{
+		minuteCandle(from, 100),
+		minuteCandle(from.Add(time.Minute), 101),
+		minuteCandle(from.Add(2*time.Minute), 102),
+		minuteCandle(from.Add(2*time.Minute), 103),
+		invalid,
+		minuteCandle(from.Add(4*time.Minute+time.Second), 104),
+		minuteCandle(to, 105),
+	}}
+
+	backfiller := New(newTestConfig("UTC"), candleStore, source)
+	reports, err := backfiller.Run(Options{Symbols: []string{"AAPL"}, Interval: models.Interval1m, From: from, To: to})
+	if err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	want := Report{Symbol: "AAPL", Fetched: 6, Invalid: 2, Duplicates: 1, Existing: 1, Imported: 2, RolledUp: 1}
+	if len(reports) != 1 || reports[0] != want {
+		t.Fatalf("Expected report %+v, got %+v", want, reports)
+	}
+
+	minutes, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m})
+	if len(minutes) != 3 {
+		t.Fatalf("Expected 3 stored 1m candles, got %d", len(minutes))
+	}
+	if minutes[1].Close != 500 {
+		t.Errorf("Expected the stored candle to be kept, got close %v", minutes[1].Close)
+	}
+	if minutes[2].Close != 103 {
+		t.Errorf("Expected the last duplicate to win, got close %v", minutes[2].Close)
+	}
+
+	rollups, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval5m})
+	if len(rollups) != 1 {
+		t.Fatalf("Expected one 5m candle, got %d", len(rollups))
+	}
+	if rollup := rollups[0]; rollup.Open != 100 || rollup.Close != 103 || rollup.High != 501 || rollup.Volume != 30 {
+		t.Errorf("Expected the 5m candle to include the stored candle, got %+v", rollup)
+	}
+}
+
+func TestBackfiller_OverwriteAndDryRun(t *testing.T) {
+	from := time.Date(2024, 6, 10, 14, 0, 0, 0, time.UTC)
+	to := from.Add(time.Minute)
+
+	candleStore := store.NewMemory()
+	stored := minuteCandle(from, 500)
+	stored.Symbol, stored.Interval = "AAPL", models.Interval1m
+	candleStore.Upsert([]*models.Candle{&stored})
+
+	backfiller := New(newTestConfig("UTC"), candleStore, &fakeSource{candles: []models.Candle{minuteCandle(from, 100)}})
+	opts := Options{Symbols: []string{"AAPL"}, Interval: models.Interval1m, From: from, To: to, Overwrite: true, DryRun: true}
+
+	reports, err := backfiller.Run(opts)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if reports[0].Imported != 1 || reports[0].RolledUp != 1 {
+		t.Errorf("Expected a dry run to report one import and rollup, got %+v", reports[0])
+	}
+	if latest, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m}); latest[0].Close != 500 {
+		t.Fatalf("Expected a dry run to keep the stored candle")
+	}
+
+	opts.DryRun = false
+	if _, err := backfiller.Run(opts); err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	if latest, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m}); latest[0].Close != 100 {
+		t.Errorf("Expected the stored candle to be overwritten, got close %v", latest[0].Close)
+	}
+}
+
+func TestBackfiller_RollsUpInBatches(t *testing.T) {
+	from := time.Date(2024, 6, 10, 14, 0, 0, 0, time.UTC)
+	buckets := writeBatchSize + 1
+
+	source := &fakeSource{}
+	for i := range 5 * buckets {
+		source.candles = append(source.candles, minuteCandle(from.Add(time.Duration(i)*time.Minute), 100))
+	}
+
+	candleStore := store.NewMemory()
+	reports, err := New(newTestConfig("UTC"), candleStore, source).Run(Options{Symbols: []string{"AAPL"}, Interval: models.Interval1m, From: from, To: from.Add(time.Duration(5*buckets) * time.Minute)})
+	if err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	if reports[0].RolledUp != buckets {
+		t.Errorf("Expected %d 5m candles to be rebuilt, got %+v", buckets, reports[0])
+	}
+	rollups, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval5m})
+	if len(rollups) != buckets || rollups[buckets-1].Volume != 50 {
+		t.Errorf("Expected %d complete 5m candles, got %d", buckets, len(rollups))
+	}
+}
+
+func TestCSVSource_ReadsFilesAndAlignsDailyCandles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily.csv")
+	data := "Date,Symbol,Open,High,Low,Close,Volume\n" +
+		"2024-06-10,AAPL,190.5,193,189,192.25,1000\n" +
+		"2024-06-11,AAPL,192,195,191,194,1200.0\n" +
+		"1718164800,MSFT,420,425,418,424,800\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestConfig("America/New_York")
+	source, err := NewCSVSource(cfg, path)
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if symbols := source.Symbols(); !slices.Equal(symbols, []string{"AAPL", "MSFT"}) {
+		t.Fatalf("Expected AAPL and MSFT, got %v", symbols)
+	}
+
+	candleStore := store.NewMemory()
+	from := time.Date(2024, 6, 10, 0, 0, 0, 0, cfg.ExchangeLocation())
+	reports, err := New(cfg, candleStore, source).Run(Options{Symbols: source.Symbols(), Interval: models.Interval1d, From: from, To: from.AddDate(0, 0, 7)})
+	if err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	if reports[0].Imported != 2 || reports[1].Imported != 1 {
+		t.Fatalf("Expected every daily candle to be imported, got %+v", reports)
+	}
+
+	candles, _, _ := candleStore.Range(models.CandleQuery{Symbol: "MSFT", Interval: models.Interval1d})
+	if want := time.Date(2024, 6, 12, 0, 0, 0, 0, cfg.ExchangeLocation()); len(candles) != 1 || !candles[0].Timestamp.Equal(want) {
+		t.Errorf("Expected the daily candle at %s, got %+v", want, candles)
+	}
+}
+
+func TestCSVSource_KeepsDailyCandlesEastOfUTC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily.csv")
+	data := "timestamp,symbol,open,high,low,close,volume\n" +
+		"2024-06-11T00:00:00+09:00,SONY,100,101,99,100.5,10\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestConfig("Asia/Tokyo")
+	source, err := NewCSVSource(cfg, path)
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	candleStore := store.NewMemory()
+	day := time.Date(2024, 6, 11, 0, 0, 0, 0, cfg.ExchangeLocation())
+	if _, err := New(cfg, candleStore, source).Run(Options{Symbols: []string{"SONY"}, Interval: models.Interval1d, From: day.AddDate(0, 0, -1), To: day.AddDate(0, 0, 2)}); err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+
+	// A row stamped at local midnight keeps its date
+	candles, _, _ := candleStore.Range(models.CandleQuery{Symbol: "SONY", Interval: models.Interval1d})
+	if len(candles) != 1 || !candles[0].Timestamp.Equal(day) {
+		t.Errorf("Expected the daily candle at %s, got %+v", day, candles)
+	}
+}
+
+func TestCSVSource_RejectsMissingColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "candles.csv")
+	if err := os.WriteFile(path, []byte("timestamp,open,high,low,close,volume\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCSVSource(newTestConfig("UTC"), path); err == nil {
+		t.Error("Expected a file without a symbol column to be rejected")
+	}
+}
+
+func TestFinnhubSource_FetchesWindowsFromStub(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 10)
+
+	var requests, limited atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/stock/candle" || query.Get("symbol") != "AAPL" || query.Get("resolution") != "1" || query.Get("token") != "secret" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		if limited.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		switch requests.Add(1) {
+		case 1:
+			if query.Get("from") != fmt.Sprint(from.Unix()) || query.Get("to") != fmt.Sprint(from.AddDate(0, 0, 7).Unix()-1) {
+				http.Error(w, "unexpected window", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"s":"ok","t":[%d,%d],"o":[1,2],"h":[1.5,2.5],"l":[0.5,1.5],"c":[1.2,2.2],"v":[100,200]}`, from.Unix(), from.Add(time.Minute).Unix())
+		default:
+			fmt.Fprint(w, `{"s":"no_data"}`)
+		}
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig("UTC")
+	cfg.FINNHUB_REST_URL = server.URL
+	source := NewFinnhubSource(cfg)
+	source.retryDelay = time.Millisecond
+
+	candles, err := source.Candles("AAPL", models.Interval1m, from, to)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected a request per week, got %d", requests.Load())
+	}
+	if len(candles) != 2 {
+		t.Fatalf("Expected 2 candles, got %d", len(candles))
+	}
+	if candle := candles[1]; !candle.Timestamp.Equal(from.Add(time.Minute)) || candle.Open != 2 || candle.High != 2.5 || candle.Close != 2.2 || candle.Volume != 200 {
+		t.Errorf("Unexpected candle %+v", candle)
+	}
+
+	if _, err := source.Candles("AAPL", models.Interval4h, from, to); err == nil {
+		t.Error("Expected 4h candles to be unsupported")
+	}
+}
+
+func TestFinnhubSource_AlignsDailyCandlesToExchangeMidnight(t *testing.T) {
+	day := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"s":"ok","t":[%d],"o":[1],"h":[1.5],"l":[0.5],"c":[1.2],"v":[100]}`, day.Unix())
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig("America/New_York")
+	cfg.FINNHUB_REST_URL = server.URL
+	from := time.Date(2024, 6, 10, 0, 0, 0, 0, cfg.ExchangeLocation())
+	candles, err := NewFinnhubSource(cfg).Candles("AAPL", models.Interval1d, from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(candles) != 1 || !candles[0].Timestamp.Equal(from) {
+		t.Errorf("Expected the daily candle at %s, got %+v", from, candles)
+	}
+}
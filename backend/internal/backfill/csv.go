@@ -0,0 +1,176 @@
+package backfill
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// CSVSource reads candles from CSV files with a header row naming the
+// symbol, timestamp, open, high, low, close and volume columns. Timestamps
+// are RFC 3339, dates in the exchange time zone or Unix seconds or
+// milliseconds.
+type CSVSource struct {
+	candles  map[string][]models.Candle
+	location *time.Location
+}
+
+// csvColumns lists the accepted header names of every column
+var csvColumns = map[string][]string{
+	"symbol":    {"symbol", "ticker"},
+	"timestamp": {"timestamp", "time", "date"},
+	"open":      {"open", "o"},
+	"high":      {"high", "h"},
+	"low":       {"low", "l"},
+	"close":     {"close", "c"},
+	"volume":    {"volume", "v"},
+}
+
+// NewCSVSource reads every candle of the files
+func NewCSVSource(cfg *config.Env, paths ...string) (*CSVSource, error) {
+	s := &CSVSource{candles: make(map[string][]models.Candle), location: cfg.ExchangeLocation()}
+	for _, path := range paths {
+		if err := s.readFile(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// Name returns the source identifier
+func (s *CSVSource) Name() string {
+	return "csv"
+}
+
+// Symbols returns the symbols found in the files, sorted
+func (s *CSVSource) Symbols() []string {
+	symbols := make([]string, 0, len(s.candles))
+	for symbol := range s.candles {
+		symbols = append(symbols, symbol)
+	}
+	slices.Sort(symbols)
+	return symbols
+}
+
+// Candles returns every candle of a symbol, leaving the range to the
+// backfiller. The files carry no interval, so the candles are taken to be of
+// the requested one.
+func (s *CSVSource) Candles(symbol string, interval models.Interval, from, to time.Time) ([]models.Candle, error) {
+	candles := slices.Clone(s.candles[symbol])
+	for i := range candles {
+		candles[i].Interval = interval
+	}
+	return candles, nil
+}
+
+// readFile adds the candles of a file
+func (s *CSVSource) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	columns, err := csvIndexes(header)
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+
+		candle, err := parseCSVCandle(record, columns, s.location)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		s.candles[candle.Symbol] = append(s.candles[candle.Symbol], candle)
+	}
+}
+
+// csvIndexes returns the position of every column in the header
+func csvIndexes(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(csvColumns))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for column, names := range csvColumns {
+			if slices.Contains(names, name) {
+				columns[column] = i
+			}
+		}
+	}
+	for column := range csvColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("missing %s column", column)
+		}
+	}
+	return columns, nil
+}
+
+// parseCSVCandle parses one row
+func parseCSVCandle(record []string, columns map[string]int, location *time.Location) (models.Candle, error) {
+	candle := models.Candle{Symbol: strings.TrimSpace(record[columns["symbol"]])}
+	if candle.Symbol == "" {
+		return candle, fmt.Errorf("missing symbol")
+	}
+
+	timestamp, err := parseTimestamp(strings.TrimSpace(record[columns["timestamp"]]), location)
+	if err != nil {
+		return candle, err
+	}
+	candle.Timestamp = timestamp
+
+	prices := map[string]*float64{"open": &candle.Open, "high": &candle.High, "low": &candle.Low, "close": &candle.Close}
+	for column, price := range prices {
+		if *price, err = strconv.ParseFloat(strings.TrimSpace(record[columns[column]]), 64); err != nil {
+			return candle, fmt.Errorf("invalid %s: %w", column, err)
+		}
+	}
+
+	volume, err := strconv.ParseFloat(strings.TrimSpace(record[columns["volume"]]), 64)
+	if err != nil {
+		return candle, fmt.Errorf("invalid volume: %w", err)
+	}
+	candle.Volume = int64(volume)
+	return candle, nil
+}
+
+// parseTimestamp parses an RFC 3339 time, a date in location or Unix seconds
+// or milliseconds
+func parseTimestamp(value string, location *time.Location) (time.Time, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// Seconds would only reach 13 digits in the year 33658
+		if len(value) >= 13 {
+			return time.UnixMilli(unix), nil
+		}
+		return time.Unix(unix, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, location); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
@@ -0,0 +1,163 @@
+package backfill
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// maxRateLimitRetries is how often a rate limited request is retried
+const maxRateLimitRetries = 3
+
+// finnhubResolutions maps intervals to Finnhub candle resolutions
+var finnhubResolutions = map[models.Interval]string{
+	models.Interval1m:  "1",
+	models.Interval5m:  "5",
+	models.Interval15m: "15",
+	models.Interval1h:  "60",
+	models.Interval1d:  "D",
+}
+
+// FinnhubSource fetches candles from the Finnhub REST candle endpoint
+type FinnhubSource struct {
+	baseURL    string
+	apiKey     string
+	location   *time.Location
+	client     *http.Client
+	retryDelay time.Duration
+}
+
+// finnhubCandles is the response of the candle endpoint, with one array
+// element per candle
+type finnhubCandles struct {
+	Status string    `json:"s"`
+	Open   []float64 `json:"o"`
+	High   []float64 `json:"h"`
+	Low    []float64 `json:"l"`
+	Close  []float64 `json:"c"`
+	Volume []float64 `json:"v"`
+	Time   []int64   `json:"t"`
+}
+
+// NewFinnhubSource creates a source reading from FINNHUB_REST_URL
+func NewFinnhubSource(cfg *config.Env) *FinnhubSource {
+	return &FinnhubSource{
+		baseURL:    cfg.FINNHUB_REST_URL,
+		apiKey:     cfg.API_KEY,
+		location:   cfg.ExchangeLocation(),
+		client:     &http.Client{Timeout: 30 * time.Second},
+		retryDelay: 5 * time.Second,
+	}
+}
+
+// Name returns the source identifier
+func (f *FinnhubSource) Name() string {
+	return "finnhub"
+}
+
+// Candles fetches the candles in windows, since Finnhub limits how many
+// intraday candles a single request returns
+func (f *FinnhubSource) Candles(symbol string, interval models.Interval, from, to time.Time) ([]models.Candle, error) {
+	resolution, ok := finnhubResolutions[interval]
+	if !ok {
+		return nil, fmt.Errorf("finnhub has no %s candles", interval)
+	}
+	window := 7 * 24 * time.Hour
+	if interval == models.Interval1d {
+		// Daily candles are stamped at midnight UTC, which is before
+		// midnight in exchanges west of UTC
+		from = from.Add(-24 * time.Hour)
+		window = 365 * 24 * time.Hour
+	}
+
+	var candles []models.Candle
+	for start := from; start.Before(to); start = start.Add(window) {
+		end := start.Add(window)
+		if end.After(to) {
+			end = to
+		}
+		fetched, err := f.fetch(symbol, interval, resolution, start, end)
+		if err != nil {
+			return candles, err
+		}
+		candles = append(candles, fetched...)
+	}
+	return candles, nil
+}
+
+// fetch requests the candles of one window, retrying when rate limited
+func (f *FinnhubSource) fetch(symbol string, interval models.Interval, resolution string, start, end time.Time) ([]models.Candle, error) {
+	query := url.Values{
+		"symbol":     {symbol},
+		"resolution": {resolution},
+		"from":       {strconv.FormatInt(start.Unix(), 10)},
+		// The range is inclusive, so the window stops short of the next one
+		"to":    {strconv.FormatInt(end.Unix()-1, 10)},
+		"token": {f.apiKey},
+	}
+	endpoint := f.baseURL + "/stock/candle?" + query.Encode()
+
+	var body finnhubCandles
+	for attempt := 1; ; attempt++ {
+		resp, err := f.client.Get(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt <= maxRateLimitRetries {
+			resp.Body.Close()
+			log.Printf("Finnhub rate limit reached, retrying %s in %s", symbol, f.retryDelay*time.Duration(attempt))
+			time.Sleep(f.retryDelay * time.Duration(attempt))
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("finnhub returned %s", resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode finnhub candles: %w", err)
+		}
+		break
+	}
+
+	switch body.Status {
+	case "no_data":
+		return nil, nil
+	case "ok":
+	default:
+		return nil, fmt.Errorf("finnhub returned status %q", body.Status)
+	}
+	n := len(body.Time)
+	if len(body.Open) != n || len(body.High) != n || len(body.Low) != n || len(body.Close) != n || len(body.Volume) != n {
+		return nil, fmt.Errorf("finnhub returned arrays of different lengths")
+	}
+
+	candles := make([]models.Candle, 0, n)
+	for i := range n {
+		timestamp := time.Unix(body.Time[i], 0)
+		if interval == models.Interval1d {
+			// Finnhub stamps daily candles at midnight UTC of their date
+			day := timestamp.UTC()
+			timestamp = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, f.location)
+		}
+		candles = append(candles, models.Candle{
+			Symbol:    symbol,
+			Interval:  interval,
+			Timestamp: timestamp,
+			Open:      body.Open[i],
+			High:      body.High[i],
+			Low:       body.Low[i],
+			Close:     body.Close[i],
+			Volume:    int64(body.Volume[i]),
+		})
+	}
+	return candles, nil
+}
@@ -0,0 +1,37 @@
+package backplane
+
+import (
+	"context"
+	"fmt"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// Backplane carries candle updates from the ingest node to edge nodes
+type Backplane interface {
+	// Name identifies the implementation
+	Name() string
+	// Publish sends an update to every subscriber
+	Publish(ctx context.Context, msg *models.BroadcastMessage) error
+	// Subscribe returns the updates published from now on. The channel is
+	// closed when ctx is done or the backplane is closed.
+	Subscribe(ctx context.Context) (<-chan *models.BroadcastMessage, error)
+	// Close releases the backplane's connections
+	Close() error
+}
+
+// New creates the backplane selected by BACKPLANE, or nil when none is
+// configured
+func New(cfg *config.Env) (Backplane, error) {
+	switch cfg.BACKPLANE {
+	case "":
+		return nil, nil
+	case "memory":
+		return NewMemory(), nil
+	case "redis":
+		return NewRedis(cfg.REDIS_URL, cfg.BACKPLANE_CHANNEL)
+	default:
+		return nil, fmt.Errorf("unknown backplane %q", cfg.BACKPLANE)
+	}
+}
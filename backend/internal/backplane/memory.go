@@ -0,0 +1,86 @@
+package backplane
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"stock-market-websocket/internal/models"
+)
+
+// memoryBufferSize is the number of updates buffered per subscriber
+const memoryBufferSize = 1000
+
+// Memory is an in-process backplane for tests and single-node setups
+type Memory struct {
+	subscribers map[chan *models.BroadcastMessage]bool
+	mutex       sync.RWMutex
+	closed      bool
+}
+
+// NewMemory creates an in-process backplane
+func NewMemory() *Memory {
+	return &Memory{subscribers: make(map[chan *models.BroadcastMessage]bool)}
+}
+
+// Name identifies the implementation
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+// Publish delivers an update to every subscriber. Updates are dropped for
+// subscribers whose buffer is full, like a pub/sub server would.
+func (m *Memory) Publish(ctx context.Context, msg *models.BroadcastMessage) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for ch := range m.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			log.Printf("Backplane subscriber buffer full, dropping %s update for %s", msg.UpdateType, msg.Candle.Symbol)
+		}
+	}
+	return ctx.Err()
+}
+
+// Subscribe returns the updates published from now on
+func (m *Memory) Subscribe(ctx context.Context) (<-chan *models.BroadcastMessage, error) {
+	ch := make(chan *models.BroadcastMessage, memoryBufferSize)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		close(ch)
+		return ch, nil
+	}
+	m.subscribers[ch] = true
+
+	go func() {
+		<-ctx.Done()
+		m.unsubscribe(ch)
+	}()
+	return ch, nil
+}
+
+// unsubscribe removes and closes a subscriber channel
+func (m *Memory) unsubscribe(ch chan *models.BroadcastMessage) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.subscribers[ch] {
+		delete(m.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Close closes every subscriber channel
+func (m *Memory) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for ch := range m.subscribers {
+		delete(m.subscribers, ch)
+		close(ch)
+	}
+	m.closed = true
+	return nil
+}
@@ -0,0 +1,59 @@
+package backplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+func TestMemory_FansOutToSubscribers(t *testing.T) {
+	bp := NewMemory()
+	defer bp.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, _ := bp.Subscribe(ctx)
+	second, _ := bp.Subscribe(context.Background())
+
+	msg := &models.BroadcastMessage{UpdateType: models.Closed, Seq: 3, Candle: &models.Candle{Symbol: "AAPL"}}
+	if err := bp.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	for _, ch := range []<-chan *models.BroadcastMessage{first, second} {
+		if got := <-ch; got.Seq != 3 || got.Candle.Symbol != "AAPL" {
+			t.Errorf("Unexpected update: %+v", got)
+		}
+	}
+
+	// Cancelled subscriptions are closed and no longer receive updates
+	cancel()
+	select {
+	case _, ok := <-first:
+		if ok {
+			t.Error("Expected no further updates after cancelling")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected subscription to be closed after cancelling")
+	}
+
+	bp.Publish(context.Background(), msg)
+	if got := <-second; got.Seq != 3 {
+		t.Errorf("Unexpected update: %+v", got)
+	}
+}
+
+func TestMemory_CloseEndsSubscriptions(t *testing.T) {
+	bp := NewMemory()
+	updates, _ := bp.Subscribe(context.Background())
+	bp.Close()
+
+	if _, ok := <-updates; ok {
+		t.Error("Expected subscription to be closed")
+	}
+	if late, _ := bp.Subscribe(context.Background()); late != nil {
+		if _, ok := <-late; ok {
+			t.Error("Expected subscription after close to be closed")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package backplane
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+const (
+	// publishQueueSize is the number of updates buffered for publishing
+	publishQueueSize = 1000
+	// publishTimeout bounds a single publish
+	publishTimeout = 2 * time.Second
+)
+
+// Publisher publishes updates to a backplane on its own goroutine so that a
+// slow or unavailable backplane never blocks trade processing. Updates are
+// dropped when the queue is full; edge nodes notice the gap in sequence
+// numbers and resync their clients.
+type Publisher struct {
+	backplane Backplane
+	queue     chan *models.BroadcastMessage
+	dropped   atomic.Int64
+	done      chan struct{}
+
+	// Publish drops updates once Close has closed the queue
+	closeMutex sync.Mutex
+	closed     bool
+}
+
+// NewPublisher creates a publisher and starts publishing
+func NewPublisher(bp Backplane) *Publisher {
+	p := &Publisher{
+		backplane: bp,
+		queue:     make(chan *models.BroadcastMessage, publishQueueSize),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish queues an update without waiting for the backplane
+func (p *Publisher) Publish(msg *models.BroadcastMessage) {
+	p.closeMutex.Lock()
+	defer p.closeMutex.Unlock()
+	if p.closed {
+		return
+	}
+
+	select {
+	case p.queue <- msg:
+	default:
+		if p.dropped.Add(1)%1000 == 1 {
+			log.Printf("Backplane publish queue full, %d updates dropped so far", p.dropped.Load())
+		}
+	}
+}
+
+// Close publishes the queued updates and stops the publisher
+func (p *Publisher) Close() {
+	p.closeMutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.closeMutex.Unlock()
+	<-p.done
+}
+
+// run publishes queued updates in order
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	for msg := range p.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		if err := p.backplane.Publish(ctx, msg); err != nil {
+			log.Printf("Failed to publish update to %s backplane: %v", p.backplane.Name(), err)
+		}
+		cancel()
+	}
+}
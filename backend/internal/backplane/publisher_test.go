@@ -0,0 +1,53 @@
+package backplane
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// stuckBackplane blocks every publish until released
+type stuckBackplane struct {
+	Memory
+	release   chan struct{}
+	published atomic.Int64
+}
+
+func (s *stuckBackplane) Publish(ctx context.Context, msg *models.BroadcastMessage) error {
+	select {
+	case <-s.release:
+		s.published.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestPublisher_DoesNotBlockOnSlowBackplane(t *testing.T) {
+	bp := &stuckBackplane{release: make(chan struct{})}
+	publisher := NewPublisher(bp)
+
+	msg := &models.BroadcastMessage{UpdateType: models.Live, Seq: 1, Candle: &models.Candle{Symbol: "AAPL"}}
+	start := time.Now()
+	for range publishQueueSize + 10 {
+		publisher.Publish(msg)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected publishing to a stuck backplane not to block, took %s", elapsed)
+	}
+	if publisher.dropped.Load() == 0 {
+		t.Error("Expected updates beyond the queue to be dropped")
+	}
+
+	close(bp.release)
+	publisher.Close()
+	if got := bp.published.Load(); got < publishQueueSize {
+		t.Errorf("Expected the queued updates to be published on close, got %d", got)
+	}
+
+	// Updates after Close are ignored
+	publisher.Publish(msg)
+}
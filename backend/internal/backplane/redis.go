@@ -0,0 +1,85 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+
+	"stock-market-websocket/internal/models"
+)
+
+// Redis carries updates over a Redis pub/sub channel as JSON
+type Redis struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedis connects to the Redis server at url
+func NewRedis(url, channel string) (*Redis, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{client: redis.NewClient(options), channel: channel}, nil
+}
+
+// Name identifies the implementation
+func (r *Redis) Name() string {
+	return "redis"
+}
+
+// Publish sends an update to every subscribed node
+func (r *Redis) Publish(ctx context.Context, msg *models.BroadcastMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.channel, data).Err()
+}
+
+// Subscribe returns the updates published from now on. The subscription
+// reconnects automatically; updates published while disconnected are lost
+// and recovered by clients through resume.
+func (r *Redis) Subscribe(ctx context.Context) (<-chan *models.BroadcastMessage, error) {
+	pubsub := r.client.Subscribe(ctx, r.channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	out := make(chan *models.BroadcastMessage, memoryBufferSize)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var msg models.BroadcastMessage
+				if err := json.Unmarshal([]byte(message.Payload), &msg); err != nil || msg.Candle == nil {
+					log.Printf("Ignoring invalid backplane message: %v", err)
+					continue
+				}
+				select {
+				case out <- &msg:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Close closes the Redis connections
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
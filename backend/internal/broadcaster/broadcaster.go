@@ -4,22 +4,29 @@ import (
 	"time"
 
 	"stock-market-websocket/internal/models"
-	"stock-market-websocket/internal/websocket"
 )
 
+// Sink is a transport that delivers updates to its clients, such as the
+// WebSocket client manager or the Server-Sent Events hub
+type Sink interface {
+	BroadcastToClients(msg *models.BroadcastMessage)
+}
+
 // Broadcaster manages real-time updates to clients
 type Broadcaster struct {
 	broadcastChan chan *models.BroadcastMessage
-	clientManager *websocket.ClientManager
+	sinks         []Sink
 	ticker        *time.Ticker
 }
 
-// NewBroadcaster creates a new broadcaster
-func NewBroadcaster(clientManager *websocket.ClientManager) *Broadcaster {
+// NewBroadcaster creates a new broadcaster sending every sink the same
+// updates, with the latest live candle of every symbol and interval sent once
+// per interval
+func NewBroadcaster(interval time.Duration, sinks ...Sink) *Broadcaster {
 	return &Broadcaster{
 		broadcastChan: make(chan *models.BroadcastMessage, 100),
-		clientManager: clientManager,
-		ticker:        time.NewTicker(1 * time.Second),
+		sinks:         sinks,
+		ticker:        time.NewTicker(interval),
 	}
 }
 
@@ -42,24 +49,50 @@ func (b *Broadcaster) Stop() {
 func (b *Broadcaster) broadcastUpdates() {
 	defer b.ticker.Stop()
 
-	var latestUpdate *models.BroadcastMessage
+	latestUpdates := make(map[models.Subscription]*models.BroadcastMessage)
 
 	for {
 		select {
 		case msg := <-b.broadcastChan:
-			// If closed candle then broadcast immediately
-			if msg.UpdateType == models.Closed {
-				b.clientManager.BroadcastToClients(msg)
-			} else {
-				// Replace temporary candle with the new live candle
-				latestUpdate = msg
-			}
+			b.handleUpdate(latestUpdates, msg)
 		case <-b.ticker.C:
-			// For every 1 second, broadcast the live candle
-			if latestUpdate != nil {
-				b.clientManager.BroadcastToClients(latestUpdate)
-			}
-			latestUpdate = nil
+			b.flush(latestUpdates)
 		}
 	}
 }
+
+// handleUpdate sends closed candles and resyncs immediately and keeps only
+// the latest live candle of each symbol and interval until the next tick
+func (b *Broadcaster) handleUpdate(latestUpdates map[models.Subscription]*models.BroadcastMessage, msg *models.BroadcastMessage) {
+	key := models.Subscription{Symbol: msg.Candle.Symbol, Interval: msg.Candle.Interval}
+
+	if msg.UpdateType != models.Closed && !msg.Resync {
+		latestUpdates[key] = msg
+		return
+	}
+
+	// A pending live candle of the closed bucket is superseded by the closed
+	// candle; one of a later bucket is sent first to keep updates in order
+	if pending, ok := latestUpdates[key]; ok {
+		if pending.Candle.Timestamp.After(msg.Candle.Timestamp) {
+			b.send(pending)
+		}
+		delete(latestUpdates, key)
+	}
+	b.send(msg)
+}
+
+// flush broadcasts the pending live candle of every symbol and interval
+func (b *Broadcaster) flush(latestUpdates map[models.Subscription]*models.BroadcastMessage) {
+	for key, latestUpdate := range latestUpdates {
+		b.send(latestUpdate)
+		delete(latestUpdates, key)
+	}
+}
+
+// send delivers an update through every sink
+func (b *Broadcaster) send(msg *models.BroadcastMessage) {
+	for _, sink := range b.sinks {
+		sink.BroadcastToClients(msg)
+	}
+}
@@ -0,0 +1,73 @@
+package broadcaster
+
+import (
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// recordingSink collects broadcast messages
+type recordingSink struct {
+	messages []*models.BroadcastMessage
+}
+
+func (s *recordingSink) BroadcastToClients(msg *models.BroadcastMessage) {
+	s.messages = append(s.messages, msg)
+}
+
+func liveUpdate(symbol string, interval models.Interval, timestamp time.Time, close float64) *models.BroadcastMessage {
+	return &models.BroadcastMessage{
+		UpdateType: models.Live,
+		Candle:     &models.Candle{Symbol: symbol, Interval: interval, Timestamp: timestamp, Close: close},
+	}
+}
+
+func TestBroadcaster_ConflatesPerSymbolAndInterval(t *testing.T) {
+	sink := &recordingSink{}
+	b := NewBroadcaster(time.Hour, sink)
+	defer b.Stop()
+
+	bucket := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	latestUpdates := make(map[models.Subscription]*models.BroadcastMessage)
+	b.handleUpdate(latestUpdates, liveUpdate("AAPL", models.Interval1m, bucket, 1))
+	b.handleUpdate(latestUpdates, liveUpdate("AAPL", models.Interval1m, bucket, 2))
+	b.handleUpdate(latestUpdates, liveUpdate("MSFT", models.Interval1m, bucket, 3))
+	b.handleUpdate(latestUpdates, liveUpdate("AAPL", models.Interval5m, bucket, 4))
+	b.flush(latestUpdates)
+
+	closes := make(map[float64]bool)
+	for _, msg := range sink.messages {
+		closes[msg.Candle.Close] = true
+	}
+	if len(sink.messages) != 3 || !closes[2] || !closes[3] || !closes[4] {
+		t.Errorf("Expected the latest live candle of each symbol and interval, got %v", closes)
+	}
+}
+
+func TestBroadcaster_ClosedCandleSupersedesPendingLive(t *testing.T) {
+	sink := &recordingSink{}
+	b := NewBroadcaster(time.Hour, sink)
+	defer b.Stop()
+
+	bucket := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	latestUpdates := make(map[models.Subscription]*models.BroadcastMessage)
+
+	b.handleUpdate(latestUpdates, liveUpdate("AAPL", models.Interval1m, bucket, 1))
+	closed := &models.BroadcastMessage{UpdateType: models.Closed, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket, Close: 2}}
+	b.handleUpdate(latestUpdates, closed)
+	b.flush(latestUpdates)
+
+	if len(sink.messages) != 1 || sink.messages[0] != closed {
+		t.Fatalf("Expected only the closed candle, got %d messages", len(sink.messages))
+	}
+
+	// A live candle of the next bucket is sent before the closed candle
+	sink.messages = nil
+	next := liveUpdate("AAPL", models.Interval1m, bucket.Add(time.Minute), 3)
+	b.handleUpdate(latestUpdates, next)
+	b.handleUpdate(latestUpdates, closed)
+	if len(sink.messages) != 2 || sink.messages[0] != next || sink.messages[1] != closed {
+		t.Errorf("Expected the next live candle before the closed candle")
+	}
+}
@@ -1,25 +1,130 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Embed time zone data for minimal container images
 
 	"github.com/caarlos0/env"
 	"github.com/joho/godotenv"
+
+	"stock-market-websocket/internal/models"
 )
 
 type Env struct {
 	SERVER_PORT string `env:"PORT" envDefault:"8080"`
 	API_KEY     string `env:"API_KEY" envDefault:""`
 
+	// Market data
+	DATA_SOURCE      string `env:"DATA_SOURCE" envDefault:"finnhub"`
+	FINNHUB_WS_URL   string `env:"FINNHUB_WS_URL" envDefault:"wss://ws.finnhub.io"`
+	FINNHUB_REST_URL string `env:"FINNHUB_REST_URL" envDefault:"https://finnhub.io/api/v1"`
+
+	// Replay data source
+	REPLAY_FILE  string  `env:"REPLAY_FILE" envDefault:""`
+	REPLAY_SPEED float64 `env:"REPLAY_SPEED" envDefault:"1"`
+	REPLAY_LOOP  bool    `env:"REPLAY_LOOP" envDefault:"false"`
+
+	// Candles
+	EXCHANGE_TIMEZONE  string        `env:"EXCHANGE_TIMEZONE" envDefault:"America/New_York"`
+	CANDLE_CLOSE_GRACE time.Duration `env:"CANDLE_CLOSE_GRACE" envDefault:"2s"`
+	CANDLE_INTERVALS   []string      `env:"CANDLE_INTERVALS" envSeparator:"," envDefault:"5m,15m,1h,4h,1d"`
+
+	// Candle persistence
+	CANDLE_WRITE_BATCH       int           `env:"CANDLE_WRITE_BATCH" envDefault:"500"`
+	CANDLE_WRITE_INTERVAL    time.Duration `env:"CANDLE_WRITE_INTERVAL" envDefault:"1s"`
+	CANDLE_WRITE_MAX_BACKOFF time.Duration `env:"CANDLE_WRITE_MAX_BACKOFF" envDefault:"30s"`
+	CANDLE_WAL_FILE          string        `env:"CANDLE_WAL_FILE" envDefault:"candles.wal"`
+
+	// Retention: comma-separated INTERVAL=AGE rules such as 1m=30d,1h=2y.
+	// Intervals without a rule are kept forever.
+	RETENTION          []string      `env:"RETENTION" envSeparator:","`
+	RETENTION_INTERVAL time.Duration `env:"RETENTION_INTERVAL" envDefault:"1h"`
+
+	// Client WebSocket
+	WS_SNAPSHOT_CANDLES int           `env:"WS_SNAPSHOT_CANDLES" envDefault:"100"`
+	BROADCAST_INTERVAL  time.Duration `env:"BROADCAST_INTERVAL" envDefault:"1s"`
+	WS_SEND_QUEUE       int           `env:"WS_SEND_QUEUE" envDefault:"256"`
+	WS_WRITE_TIMEOUT    time.Duration `env:"WS_WRITE_TIMEOUT" envDefault:"10s"`
+	WS_MAX_OVERFLOWS    int           `env:"WS_MAX_OVERFLOWS" envDefault:"10"`
+	WS_PING_INTERVAL    time.Duration `env:"WS_PING_INTERVAL" envDefault:"30s"`
+	WS_PONG_TIMEOUT     time.Duration `env:"WS_PONG_TIMEOUT" envDefault:"30s"`
+
+	// Client Server-Sent Events
+	SSE_SEND_QUEUE    int           `env:"SSE_SEND_QUEUE" envDefault:"256"`
+	SSE_PING_INTERVAL time.Duration `env:"SSE_PING_INTERVAL" envDefault:"30s"`
+
+	// Scaling: ROLE is all, ingest (trades in, updates out to the backplane)
+	// or edge (updates from the backplane out to clients)
+	ROLE              string `env:"ROLE" envDefault:"all"`
+	BACKPLANE         string `env:"BACKPLANE" envDefault:""`
+	REDIS_URL         string `env:"REDIS_URL" envDefault:"redis://localhost:6379/0"`
+	BACKPLANE_CHANNEL string `env:"BACKPLANE_CHANNEL" envDefault:"candles"`
+
+	// Leader election between replicas that ingest trades
+	LEADER_ELECTION       bool          `env:"LEADER_ELECTION" envDefault:"true"`
+	LEADER_LOCK_KEY       int64         `env:"LEADER_LOCK_KEY" envDefault:"72604170"`
+	LEADER_RETRY_INTERVAL time.Duration `env:"LEADER_RETRY_INTERVAL" envDefault:"5s"`
+
+	// Tick recording
+	TICK_RECORDER        string        `env:"TICK_RECORDER" envDefault:""`
+	TICK_RECORDER_DIR    string        `env:"TICK_RECORDER_DIR" envDefault:"ticks"`
+	TICK_RECORDER_ROTATE time.Duration `env:"TICK_RECORDER_ROTATE" envDefault:"1h"`
+
+	// Candle storage: postgres, sqlite or memory
+	STORE       string `env:"STORE" envDefault:"postgres"`
+	SQLITE_PATH string `env:"SQLITE_PATH" envDefault:"stock_tracker.db"`
+
+	// TimescaleDB hypertable, compression and continuous aggregates
+	TIMESCALE                bool          `env:"TIMESCALE" envDefault:"false"`
+	TIMESCALE_CHUNK_INTERVAL time.Duration `env:"TIMESCALE_CHUNK_INTERVAL" envDefault:"168h"`
+	TIMESCALE_COMPRESS_AFTER time.Duration `env:"TIMESCALE_COMPRESS_AFTER" envDefault:"168h"`
+
 	// Database
 	DB_HOST     string `env:"DB_HOST" envDefault:"localhost"`
 	DB_USER     string `env:"DB_USER" envDefault:"postgres"`
 	DB_PASSWORD string `env:"DB_PASSWORD" envDefault:""`
 	DB_NAME     string `env:"DB_NAME" envDefault:"stock_tracker"`
 	DB_SSL_MODE string `env:"DB_SSL_MODE" envDefault:"disable"`
+
+	// Apply pending schema migrations at startup rather than only through
+	// the migrate command
+	DB_MIGRATE_ON_START bool `env:"DB_MIGRATE_ON_START" envDefault:"true"`
 }
 
+// Candle stores
+const (
+	StorePostgres = "postgres"
+	StoreSQLite   = "sqlite"
+	StoreMemory   = "memory"
+)
+
+// Node roles
+const (
+	RoleAll    = "all"
+	RoleIngest = "ingest"
+	RoleEdge   = "edge"
+)
+
+// Load reads and validates the configuration of the server
 func Load() *Env {
+	config := load()
+	config.validateServer()
+	return config
+}
+
+// LoadCommand reads and validates the configuration of a maintenance
+// command, which neither ingests trades nor serves clients
+func LoadCommand() *Env {
+	return load()
+}
+
+// load reads the configuration and validates the settings shared by the
+// server and maintenance commands
+func load() *Env {
 	// Try to load .env file (for local development)
 	// Don't fail if it doesn't exist (for production deployments)
 	if err := godotenv.Load(); err != nil {
@@ -34,10 +139,67 @@ func Load() *Env {
 	// Log configuration (without sensitive data)
 	log.Printf("Configuration loaded:")
 	log.Printf("  SERVER_PORT: %s", config.SERVER_PORT)
+	log.Printf("  ROLE: %s", config.ROLE)
+	log.Printf("  BACKPLANE: %s", func() string {
+		if config.BACKPLANE == "" {
+			return "disabled"
+		}
+		return config.BACKPLANE
+	}())
+	log.Printf("  LEADER_ELECTION: %s", func() string {
+		if config.LEADER_ELECTION && !config.ElectsLeader() {
+			return "disabled (requires the postgres store)"
+		}
+		return fmt.Sprint(config.LEADER_ELECTION)
+	}())
+	log.Printf("  DATA_SOURCE: %s", config.DATA_SOURCE)
+	if config.DATA_SOURCE == "replay" {
+		log.Printf("  REPLAY_FILE: %s", config.REPLAY_FILE)
+		log.Printf("  REPLAY_SPEED: %.2f", config.REPLAY_SPEED)
+		log.Printf("  REPLAY_LOOP: %t", config.REPLAY_LOOP)
+	}
+	log.Printf("  EXCHANGE_TIMEZONE: %s", config.EXCHANGE_TIMEZONE)
+	log.Printf("  CANDLE_CLOSE_GRACE: %s", config.CANDLE_CLOSE_GRACE)
+	log.Printf("  CANDLE_INTERVALS: %s", strings.Join(config.CANDLE_INTERVALS, ","))
+	log.Printf("  CANDLE_WRITE_BATCH: %d", config.CANDLE_WRITE_BATCH)
+	log.Printf("  CANDLE_WRITE_INTERVAL: %s", config.CANDLE_WRITE_INTERVAL)
+	log.Printf("  CANDLE_WRITE_MAX_BACKOFF: %s", config.CANDLE_WRITE_MAX_BACKOFF)
+	log.Printf("  CANDLE_WAL_FILE: %s", config.CANDLE_WAL_FILE)
+	log.Printf("  RETENTION: %s", func() string {
+		if len(config.RETENTION) == 0 {
+			return "disabled"
+		}
+		return strings.Join(config.RETENTION, ",")
+	}())
+	log.Printf("  WS_SNAPSHOT_CANDLES: %d", config.WS_SNAPSHOT_CANDLES)
+	log.Printf("  BROADCAST_INTERVAL: %s", config.BROADCAST_INTERVAL)
+	log.Printf("  WS_SEND_QUEUE: %d", config.WS_SEND_QUEUE)
+	log.Printf("  WS_WRITE_TIMEOUT: %s", config.WS_WRITE_TIMEOUT)
+	log.Printf("  WS_MAX_OVERFLOWS: %d", config.WS_MAX_OVERFLOWS)
+	log.Printf("  WS_PING_INTERVAL: %s", config.WS_PING_INTERVAL)
+	log.Printf("  WS_PONG_TIMEOUT: %s", config.WS_PONG_TIMEOUT)
+	log.Printf("  SSE_SEND_QUEUE: %d", config.SSE_SEND_QUEUE)
+	log.Printf("  SSE_PING_INTERVAL: %s", config.SSE_PING_INTERVAL)
+	log.Printf("  TICK_RECORDER: %s", func() string {
+		if config.TICK_RECORDER == "" {
+			return "disabled"
+		}
+		return config.TICK_RECORDER
+	}())
+	log.Printf("  STORE: %s", config.STORE)
+	if config.STORE == StoreSQLite {
+		log.Printf("  SQLITE_PATH: %s", config.SQLITE_PATH)
+	}
+	log.Printf("  TIMESCALE: %t", config.TIMESCALE)
+	if config.TIMESCALE {
+		log.Printf("  TIMESCALE_CHUNK_INTERVAL: %s", config.TIMESCALE_CHUNK_INTERVAL)
+		log.Printf("  TIMESCALE_COMPRESS_AFTER: %s", config.TIMESCALE_COMPRESS_AFTER)
+	}
 	log.Printf("  DB_HOST: %s", config.DB_HOST)
 	log.Printf("  DB_USER: %s", config.DB_USER)
 	log.Printf("  DB_NAME: %s", config.DB_NAME)
 	log.Printf("  DB_SSL_MODE: %s", config.DB_SSL_MODE)
+	log.Printf("  DB_MIGRATE_ON_START: %t", config.DB_MIGRATE_ON_START)
 	log.Printf("  API_KEY: %s", func() string {
 		if config.API_KEY == "" {
 			return "NOT SET"
@@ -46,9 +208,173 @@ func Load() *Env {
 	}())
 
 	// Validate required environment variables
-	if config.API_KEY == "" {
-		log.Fatalf("API_KEY environment variable is required")
+	if _, err := time.LoadLocation(config.EXCHANGE_TIMEZONE); err != nil {
+		log.Fatalf("Invalid EXCHANGE_TIMEZONE %q: %v", config.EXCHANGE_TIMEZONE, err)
+	}
+	for _, interval := range config.CANDLE_INTERVALS {
+		if _, err := models.ParseInterval(interval); err != nil {
+			log.Fatalf("Invalid CANDLE_INTERVALS: %v", err)
+		}
+	}
+	if config.CANDLE_WRITE_BATCH <= 0 || config.CANDLE_WRITE_INTERVAL <= 0 || config.CANDLE_WRITE_MAX_BACKOFF <= 0 {
+		log.Fatalf("CANDLE_WRITE_BATCH, CANDLE_WRITE_INTERVAL and CANDLE_WRITE_MAX_BACKOFF must be positive")
+	}
+	if config.CANDLE_WAL_FILE == "" {
+		log.Fatalf("CANDLE_WAL_FILE environment variable is required")
+	}
+	retention, err := config.Retention()
+	if err != nil {
+		log.Fatalf("Invalid RETENTION: %v", err)
+	}
+	if len(retention) > 0 && config.RETENTION_INTERVAL <= 0 {
+		log.Fatalf("RETENTION_INTERVAL must be positive")
+	}
+	if config.WS_SNAPSHOT_CANDLES < 0 {
+		log.Fatalf("WS_SNAPSHOT_CANDLES must not be negative")
+	}
+	if config.BROADCAST_INTERVAL <= 0 {
+		log.Fatalf("BROADCAST_INTERVAL must be positive")
+	}
+	if config.WS_SEND_QUEUE <= 0 || config.WS_WRITE_TIMEOUT <= 0 || config.WS_MAX_OVERFLOWS < 0 {
+		log.Fatalf("WS_SEND_QUEUE and WS_WRITE_TIMEOUT must be positive and WS_MAX_OVERFLOWS must not be negative")
+	}
+	if config.WS_PING_INTERVAL <= 0 || config.WS_PONG_TIMEOUT <= 0 {
+		log.Fatalf("WS_PING_INTERVAL and WS_PONG_TIMEOUT must be positive")
+	}
+	if config.SSE_SEND_QUEUE <= 0 || config.SSE_PING_INTERVAL <= 0 {
+		log.Fatalf("SSE_SEND_QUEUE and SSE_PING_INTERVAL must be positive")
+	}
+	switch config.STORE {
+	case StorePostgres, StoreMemory:
+	case StoreSQLite:
+		if config.SQLITE_PATH == "" {
+			log.Fatalf("SQLITE_PATH environment variable is required for the sqlite store")
+		}
+	default:
+		log.Fatalf("Invalid STORE %q, expected postgres, sqlite or memory", config.STORE)
+	}
+	if config.TIMESCALE {
+		if config.STORE != StorePostgres {
+			log.Fatalf("TIMESCALE requires the postgres store")
+		}
+		if config.TIMESCALE_CHUNK_INTERVAL < time.Hour || config.TIMESCALE_COMPRESS_AFTER < time.Hour {
+			log.Fatalf("TIMESCALE_CHUNK_INTERVAL and TIMESCALE_COMPRESS_AFTER must be at least 1h")
+		}
+		// Continuous aggregates are refreshed from one-minute candles up to
+		// 30 days back, so older minutes must still exist
+		if keep, ok := retention[models.Interval1m]; ok && keep < 30*24*time.Hour {
+			log.Fatalf("TIMESCALE requires RETENTION to keep 1m candles for at least 30d")
+		}
+	}
+	if config.STORE == StoreMemory && config.TICK_RECORDER == "db" {
+		log.Fatalf("TICK_RECORDER=db requires a database store")
+	}
+	if config.ElectsLeader() && config.LEADER_RETRY_INTERVAL <= 0 {
+		log.Fatalf("LEADER_RETRY_INTERVAL must be positive")
 	}
 
 	return config
 }
+
+// ExchangeLocation returns the time zone candles are aligned in
+func (e *Env) ExchangeLocation() *time.Location {
+	location, err := time.LoadLocation(e.EXCHANGE_TIMEZONE)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// validateServer checks the settings that only the server uses
+func (e *Env) validateServer() {
+	switch e.ROLE {
+	case RoleAll:
+	case RoleIngest, RoleEdge:
+		if e.BACKPLANE == "" {
+			log.Fatalf("BACKPLANE environment variable is required for the %s role", e.ROLE)
+		}
+		// The memory backplane cannot reach other processes
+		if e.BACKPLANE == "memory" {
+			log.Fatalf("BACKPLANE=memory only connects nodes in one process and requires ROLE=all")
+		}
+	default:
+		log.Fatalf("Invalid ROLE %q, expected all, ingest or edge", e.ROLE)
+	}
+	switch e.DATA_SOURCE {
+	case "finnhub":
+		if e.API_KEY == "" && e.IngestsTrades() {
+			log.Fatalf("API_KEY environment variable is required for the finnhub data source")
+		}
+	case "replay":
+		if e.REPLAY_FILE == "" && e.IngestsTrades() {
+			log.Fatalf("REPLAY_FILE environment variable is required for the replay data source")
+		}
+		// 0 replays as fast as possible
+		if e.REPLAY_SPEED < 0 {
+			log.Fatalf("REPLAY_SPEED must not be negative")
+		}
+	}
+}
+
+// Retention parses RETENTION into the age after which candles of each
+// interval are deleted. Ages are Go durations or whole days (d), weeks (w)
+// or years (y) such as 30d or 2y.
+func (e *Env) Retention() (map[models.Interval]time.Duration, error) {
+	retention := make(map[models.Interval]time.Duration)
+	for _, rule := range e.RETENTION {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		name, age, found := strings.Cut(rule, "=")
+		if !found {
+			return nil, fmt.Errorf("rule %q is not INTERVAL=AGE", rule)
+		}
+		interval, err := models.ParseInterval(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		keep, err := parseAge(strings.TrimSpace(age))
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule, err)
+		}
+		if keep < interval.Duration() {
+			return nil, fmt.Errorf("rule %q keeps less than one %s candle", rule, interval)
+		}
+		retention[interval] = keep
+	}
+	return retention, nil
+}
+
+// parseAge parses a Go duration or a whole number of days, weeks or years
+func parseAge(age string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour, "y": 365 * 24 * time.Hour}
+	if unit, ok := units[age[max(len(age)-1, 0):]]; ok {
+		count, err := strconv.Atoi(age[:len(age)-1])
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid age %q", age)
+		}
+		return time.Duration(count) * unit, nil
+	}
+	keep, err := time.ParseDuration(age)
+	if err != nil || keep <= 0 {
+		return 0, fmt.Errorf("invalid age %q", age)
+	}
+	return keep, nil
+}
+
+// ElectsLeader reports whether replicas elect the ingesting node, which
+// relies on Postgres advisory locks
+func (e *Env) ElectsLeader() bool {
+	return e.LEADER_ELECTION && e.STORE == StorePostgres
+}
+
+// IngestsTrades reports whether the node reads trades and builds candles
+func (e *Env) IngestsTrades() bool {
+	return e.ROLE != RoleEdge
+}
+
+// ServesClients reports whether the node streams updates to clients
+func (e *Env) ServesClients() bool {
+	return e.ROLE != RoleIngest
+}
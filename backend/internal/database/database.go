@@ -4,33 +4,58 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
 	"stock-market-websocket/internal/config"
-	"stock-market-websocket/internal/models"
 )
 
-// Connect establishes a database connection
+// Connect establishes a connection to the Postgres or SQLite database
+// selected by STORE and brings its schema up to date
 func Connect(cfg *config.Env) *gorm.DB {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.DB_HOST,
-		cfg.DB_USER,
-		cfg.DB_PASSWORD,
-		cfg.DB_NAME,
-		cfg.DB_SSL_MODE,
-	)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := Open(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Auto migrate the schema
-	if err := db.AutoMigrate(&models.Candle{}); err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+	if cfg.DB_MIGRATE_ON_START {
+		if _, err := MigrateUp(db); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	} else if pending, err := Pending(db); err != nil {
+		log.Fatalf("Failed to check migrations: %v", err)
+	} else if pending > 0 {
+		log.Fatalf("Database has %d pending migrations, run the migrate command", pending)
+	}
+
+	if cfg.TIMESCALE {
+		if err := setupTimescale(db, cfg); err != nil {
+			log.Fatalf("Failed to set up TimescaleDB: %v", err)
+		}
 	}
 
 	log.Printf("Database connected successfully")
 	return db
 }
+
+// Open connects to the database selected by STORE without migrating it
+func Open(cfg *config.Env) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.STORE {
+	case config.StoreSQLite:
+		// Readers and the candle writer share the file, so writers wait for
+		// the lock rather than fail
+		dialector = sqlite.Open(cfg.SQLITE_PATH + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	default:
+		dialector = postgres.Open(fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=%s",
+			cfg.DB_HOST,
+			cfg.DB_USER,
+			cfg.DB_PASSWORD,
+			cfg.DB_NAME,
+			cfg.DB_SSL_MODE,
+		))
+	}
+
+	return gorm.Open(dialector, &gorm.Config{TranslateError: true})
+}
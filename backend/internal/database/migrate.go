@@ -0,0 +1,259 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles holds the up and down scripts of every dialect
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockKey is the Postgres advisory lock serializing migrations run
+// by replicas starting at the same time
+const migrationLockKey = 72604171
+
+// migrationName matches script names such as 0002_unique_candle_key.up.sql
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the schema_migrations table
+type schemaMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migrations returns the migrations of a dialect ordered by version
+func Migrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration in order and returns the number
+// applied. Each migration runs in its own transaction together with its
+// schema_migrations row.
+func MigrateUp(db *gorm.DB) (int, error) {
+	statuses, err := Status(db)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+		done, err := migrate(db, status.Migration, true)
+		if err != nil {
+			return applied, err
+		}
+		if done {
+			log.Printf("Applied migration %04d_%s", status.Version, status.Name)
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts up to steps applied migrations, newest first, and
+// returns the number reverted
+func MigrateDown(db *gorm.DB, steps int) (int, error) {
+	statuses, err := Status(db)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for _, status := range slices.Backward(statuses) {
+		if reverted == steps {
+			break
+		}
+		if status.AppliedAt == nil {
+			continue
+		}
+		done, err := migrate(db, status.Migration, false)
+		if err != nil {
+			return reverted, err
+		}
+		if done {
+			log.Printf("Reverted migration %04d_%s", status.Version, status.Name)
+			reverted++
+		}
+	}
+	return reverted, nil
+}
+
+// Status returns every migration of the database's dialect and when it was
+// applied
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err := createSchemaMigrations(db); err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	appliedAt := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i].Migration = migration
+		if at, ok := appliedAt[migration.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Pending returns the number of migrations not yet applied
+func Pending(db *gorm.DB) (int, error) {
+	statuses, err := Status(db)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// migrate runs the up or down script of a migration. It reports false when
+// another process applied or reverted the migration first.
+func migrate(db *gorm.DB, migration Migration, up bool) (bool, error) {
+	done := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockKey).Error; err != nil {
+				return err
+			}
+		}
+
+		var count int64
+		if err := tx.Model(&schemaMigration{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+			return err
+		}
+		if (count > 0) == up {
+			return nil
+		}
+
+		script := migration.Down
+		if up {
+			script = migration.Up
+		}
+		if err := tx.Exec(script).Error; err != nil {
+			return err
+		}
+
+		if up {
+			err := tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}).Error
+			if err != nil {
+				return err
+			}
+		} else if err := tx.Where("version = ?", migration.Version).Delete(&schemaMigration{}).Error; err != nil {
+			return err
+		}
+		done = true
+		return nil
+	})
+	if err != nil {
+		direction := "down"
+		if up {
+			direction = "up"
+		}
+		return false, fmt.Errorf("migration %04d_%s %s failed: %w", migration.Version, migration.Name, direction, err)
+	}
+	return done, nil
+}
+
+// createSchemaMigrations creates the table recording applied migrations
+func createSchemaMigrations(db *gorm.DB) error {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp NOT NULL
+	)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/models"
+)
+
+func openTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	return db
+}
+
+func TestMigrations_Dialects(t *testing.T) {
+	postgres, err := Migrations("postgres")
+	if err != nil {
+		t.Fatalf("Failed to load postgres migrations: %v", err)
+	}
+	sqlite, err := Migrations("sqlite")
+	if err != nil {
+		t.Fatalf("Failed to load sqlite migrations: %v", err)
+	}
+
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("Expected the same migrations for every dialect, got %d and %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Name != sqlite[i].Name {
+			t.Errorf("Migration %d is %s for postgres but %s for sqlite", i+1, postgres[i].Name, sqlite[i].Name)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db := openTestDatabase(t)
+
+	applied, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	migrations, _ := Migrations("sqlite")
+	if applied != len(migrations) {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(migrations), applied)
+	}
+	if applied, err := MigrateUp(db); err != nil || applied != 0 {
+		t.Errorf("Expected a second run to apply nothing, got %d (%v)", applied, err)
+	}
+	if !db.Migrator().HasIndex("candles", "uniq_candles_symbol_interval_timestamp") || !db.Migrator().HasTable("trades") {
+		t.Error("Expected the candles index and trades table to exist")
+	}
+
+	if reverted, err := MigrateDown(db, 1); err != nil || reverted != 1 {
+		t.Fatalf("Expected one migration to be reverted, got %d (%v)", reverted, err)
+	}
+	if db.Migrator().HasTable("trades") {
+		t.Error("Expected the trades table to be dropped")
+	}
+	if pending, err := Pending(db); err != nil || pending != 1 {
+		t.Errorf("Expected one pending migration, got %d (%v)", pending, err)
+	}
+
+	if _, err := MigrateDown(db, len(migrations)); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+	if db.Migrator().HasTable("candles") {
+		t.Error("Expected the candles table to be dropped")
+	}
+}
+
+func TestMigrateUp_AdoptsAutoMigratedDatabase(t *testing.T) {
+	db := openTestDatabase(t)
+
+	// Schema and rows created by earlier versions through AutoMigrate
+	if err := db.AutoMigrate(&models.Candle{}, &models.Trade{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+	candle := models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Close: 1, Volume: 5, Timestamp: time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)}
+	if err := db.Create(&candle).Error; err != nil {
+		t.Fatalf("Failed to create candle: %v", err)
+	}
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	var stored []models.Candle
+	if err := db.Find(&stored).Error; err != nil || len(stored) != 1 || stored[0].Volume != 5 {
+		t.Errorf("Expected the existing candle to be kept, got %+v (%v)", stored, err)
+	}
+}
+
+// openTestPostgres connects to the Postgres database of TEST_POSTGRES_DSN, a
+// key/value connection string such as "host=localhost user=postgres", in
+// a schema of its own, which is dropped when the test ends. Tests using it
+// are skipped without TEST_POSTGRES_DSN.
+func openTestPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	return db
+}
+
+func TestMigrateUp_UpgradesLegacyRootDatabase(t *testing.T) {
+	db := openTestPostgres(t)
+
+	// Schema of the legacy root binary's Candle, without id, interval and
+	// volume, and a duplicate it could write for the same minute
+	err := db.Exec(`CREATE TABLE candles (
+		symbol text,
+		open decimal,
+		high decimal,
+		low decimal,
+		close decimal,
+		timestamp timestamptz
+	)`).Error
+	if err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+	minute := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	for _, close := range []float64{1, 2} {
+		if err := db.Exec("INSERT INTO candles (symbol, open, high, low, close, timestamp) VALUES ('AAPL', 1, 2, 1, ?, ?)", close, minute).Error; err != nil {
+			t.Fatalf("Failed to insert legacy candle: %v", err)
+		}
+	}
+
+	migrations, _ := Migrations("postgres")
+	if applied, err := MigrateUp(db); err != nil || applied != len(migrations) {
+		t.Fatalf("Expected %d migrations to be applied, got %d (%v)", len(migrations), applied, err)
+	}
+
+	var stored []models.Candle
+	if err := db.Find(&stored).Error; err != nil || len(stored) != 1 {
+		t.Fatalf("Expected one candle per key to be kept, got %+v (%v)", stored, err)
+	}
+	if candle := stored[0]; candle.ID == 0 || candle.Interval != models.Interval1m || candle.Volume != 0 || candle.Close != 2 {
+		t.Errorf("Expected the newest legacy candle with an id, 1m interval and no volume, got %+v", candle)
+	}
+	if err := db.Create(&models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: minute.Add(time.Minute)}).Error; err != nil {
+		t.Errorf("Expected new candles to get an id, got %v", err)
+	}
+
+	// Reverting the unique key leaves the indexes of 0001, none but the
+	// primary key
+	if reverted, err := MigrateDown(db, 2); err != nil || reverted != 2 {
+		t.Fatalf("Expected two migrations to be reverted, got %d (%v)", reverted, err)
+	}
+	var indexes []string
+	if err := db.Raw("SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = 'candles' AND indexname <> 'candles_pkey'").Scan(&indexes).Error; err != nil || len(indexes) != 0 {
+		t.Errorf("Expected only the primary key after reverting, got %v (%v)", indexes, err)
+	}
+	if applied, err := MigrateUp(db); err != nil || applied != 2 {
+		t.Errorf("Expected the reverted migrations to apply again, got %d (%v)", applied, err)
+	}
+}
@@ -0,0 +1,27 @@
+-- Candles, matching the schema AutoMigrate created before versioned
+-- migrations. Every statement tolerates an existing table so that databases
+-- created by AutoMigrate or by the legacy root binary are upgraded in place.
+CREATE TABLE IF NOT EXISTS candles (
+    id bigserial PRIMARY KEY,
+    symbol text,
+    "interval" varchar(8) NOT NULL DEFAULT '1m',
+    open decimal,
+    high decimal,
+    low decimal,
+    close decimal,
+    volume bigint,
+    timestamp timestamptz
+);
+
+-- The legacy root binary created candles without these columns
+ALTER TABLE candles ADD COLUMN IF NOT EXISTS id bigserial;
+ALTER TABLE candles ADD COLUMN IF NOT EXISTS "interval" varchar(8) NOT NULL DEFAULT '1m';
+ALTER TABLE candles ADD COLUMN IF NOT EXISTS volume bigint;
+UPDATE candles SET volume = 0 WHERE volume IS NULL;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'candles'::regclass AND contype = 'p') THEN
+        ALTER TABLE candles ADD PRIMARY KEY (id);
+    END IF;
+END $$;
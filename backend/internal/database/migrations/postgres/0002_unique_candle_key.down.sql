@@ -0,0 +1 @@
+DROP INDEX IF EXISTS uniq_candles_symbol_interval_timestamp;
@@ -0,0 +1,12 @@
+-- Keep the most recently written candle of every key so the unique index
+-- can be built
+DELETE FROM candles older USING candles newer
+WHERE older.symbol = newer.symbol
+    AND older."interval" = newer."interval"
+    AND older.timestamp = newer.timestamp
+    AND older.id < newer.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_candles_symbol_interval_timestamp ON candles (symbol, "interval", timestamp);
+
+-- Replaced by the unique index
+DROP INDEX IF EXISTS idx_candles_symbol_interval_timestamp;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS trades (
+    id bigserial PRIMARY KEY,
+    symbol text,
+    price decimal,
+    volume bigint,
+    timestamp timestamptz,
+    conditions text
+);
+
+CREATE INDEX IF NOT EXISTS idx_trades_symbol_timestamp ON trades (symbol, timestamp);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS candles (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    symbol text,
+    "interval" varchar(8) NOT NULL DEFAULT '1m',
+    open real,
+    high real,
+    low real,
+    close real,
+    volume integer,
+    timestamp datetime
+);
@@ -0,0 +1,5 @@
+DELETE FROM candles WHERE id NOT IN (
+    SELECT MAX(id) FROM candles GROUP BY symbol, "interval", timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_candles_symbol_interval_timestamp ON candles (symbol, "interval", timestamp);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS trades (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    symbol text,
+    price real,
+    volume integer,
+    timestamp datetime,
+    conditions text
+);
+
+CREATE INDEX IF NOT EXISTS idx_trades_symbol_timestamp ON trades (symbol, timestamp);
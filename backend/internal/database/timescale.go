@@ -0,0 +1,185 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// minTimescaleVersion is the oldest TimescaleDB release that can upsert into
+// and delete from compressed chunks, which late candles and retention do
+var minTimescaleVersion = [2]int{2, 11}
+
+// AggregateView returns the continuous aggregate holding the candles of an
+// interval rolled up from one-minute candles
+func AggregateView(interval models.Interval) string {
+	return "candles_" + string(interval)
+}
+
+// setupTimescale turns the candles table into a hypertable partitioned on
+// timestamp, compresses chunks older than TIMESCALE_COMPRESS_AFTER and
+// defines a continuous aggregate for every interval above one minute, with
+// the retention of RETENTION. Every step is skipped when already done, so it
+// runs on each startup.
+func setupTimescale(db *gorm.DB, cfg *config.Env) error {
+	retention, err := cfg.Retention()
+	if err != nil {
+		return err
+	}
+
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS timescaledb").Error; err != nil {
+		return fmt.Errorf("failed to enable the timescaledb extension: %w", err)
+	}
+
+	var version string
+	if err := db.Raw("SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'").Scan(&version).Error; err != nil {
+		return fmt.Errorf("failed to read the timescaledb version: %w", err)
+	}
+	if err := checkTimescaleVersion(version); err != nil {
+		return err
+	}
+
+	var hypertable struct {
+		Found       bool
+		Compression bool
+	}
+	err = db.Raw(`SELECT COUNT(*) > 0 AS found, COALESCE(BOOL_OR(compression_enabled), false) AS compression
+		FROM timescaledb_information.hypertables WHERE hypertable_name = 'candles'`).Scan(&hypertable).Error
+	if err != nil {
+		return fmt.Errorf("failed to inspect hypertables: %w", err)
+	}
+
+	var statements []string
+	if !hypertable.Found {
+		statements = append(statements, hypertableStatements(cfg.TIMESCALE_CHUNK_INTERVAL)...)
+	}
+	if !hypertable.Compression {
+		statements = append(statements, compressionStatements(cfg.TIMESCALE_COMPRESS_AFTER)...)
+	}
+	for _, interval := range models.Intervals[1:] {
+		statements = append(statements, aggregateStatements(interval, cfg.EXCHANGE_TIMEZONE)...)
+		statements = append(statements, retentionStatements(interval, retention[interval])...)
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to run %q: %w", statement, err)
+		}
+	}
+
+	if !hypertable.Found {
+		log.Printf("Converted candles into a hypertable")
+	}
+	return nil
+}
+
+// checkTimescaleVersion rejects TimescaleDB releases older than
+// minTimescaleVersion
+func checkTimescaleVersion(version string) error {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) >= 2 {
+		major, majorErr := strconv.Atoi(parts[0])
+		minor, minorErr := strconv.Atoi(parts[1])
+		if majorErr == nil && minorErr == nil {
+			if major > minTimescaleVersion[0] || major == minTimescaleVersion[0] && minor >= minTimescaleVersion[1] {
+				return nil
+			}
+			return fmt.Errorf("timescaledb %s is too old, STORE=timescale needs %d.%d or later (run ALTER EXTENSION timescaledb UPDATE after upgrading)",
+				version, minTimescaleVersion[0], minTimescaleVersion[1])
+		}
+	}
+	return fmt.Errorf("unrecognized timescaledb version %q", version)
+}
+
+// hypertableStatements convert the candles table into a hypertable. Unique
+// indexes of a hypertable must include the partitioning column, so the
+// primary key is widened to (id, timestamp) first.
+func hypertableStatements(chunkInterval time.Duration) []string {
+	return []string{
+		`ALTER TABLE candles DROP CONSTRAINT IF EXISTS candles_pkey`,
+		`ALTER TABLE candles ADD PRIMARY KEY (id, timestamp)`,
+		fmt.Sprintf(`SELECT create_hypertable('candles', 'timestamp', chunk_time_interval => %s, migrate_data => true, if_not_exists => true)`, pgInterval(chunkInterval)),
+	}
+}
+
+// compressionStatements enable native compression, segmenting chunks by
+// series so that range scans of one symbol decompress little data
+func compressionStatements(compressAfter time.Duration) []string {
+	return []string{
+		`ALTER TABLE candles SET (timescaledb.compress, timescaledb.compress_segmentby = 'symbol, "interval"', timescaledb.compress_orderby = 'timestamp DESC')`,
+		fmt.Sprintf(`SELECT add_compression_policy('candles', %s, if_not_exists => true)`, pgInterval(compressAfter)),
+	}
+}
+
+// aggregateStatements define the continuous aggregate of an interval and
+// its refresh policy. Buckets follow the exchange time zone like the candles
+// built by the candle service, and recent buckets not yet materialized are
+// computed at query time.
+func aggregateStatements(interval models.Interval, timezone string) []string {
+	view := AggregateView(interval)
+	width := bucketWidth(interval)
+
+	// The refresh window must span at least two buckets
+	lookback := 3 * 24 * time.Hour
+	if interval == models.Interval1d {
+		lookback = 30 * 24 * time.Hour
+	}
+	schedule := min(interval.Duration(), time.Hour)
+
+	return []string{
+		fmt.Sprintf(`CREATE MATERIALIZED VIEW IF NOT EXISTS %s
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT symbol,
+	time_bucket(INTERVAL '%s', timestamp, '%s') AS bucket,
+	first(open, timestamp) AS open,
+	max(high) AS high,
+	min(low) AS low,
+	last(close, timestamp) AS close,
+	sum(volume) AS volume
+FROM candles
+WHERE "interval" = '%s'
+GROUP BY symbol, bucket
+WITH NO DATA`, view, width, timezone, models.Interval1m),
+		fmt.Sprintf(`SELECT add_continuous_aggregate_policy('%s', start_offset => %s, end_offset => %s, schedule_interval => %s, if_not_exists => true)`,
+			view, pgInterval(lookback), pgInterval(interval.Duration()), pgInterval(schedule)),
+	}
+}
+
+// retentionStatements replace the retention policy of an interval's
+// continuous aggregate, which drops its materialized buckets older than
+// keep. A zero keep leaves the buckets forever.
+func retentionStatements(interval models.Interval, keep time.Duration) []string {
+	view := AggregateView(interval)
+	statements := []string{
+		fmt.Sprintf(`SELECT remove_retention_policy('%s', if_exists => true)`, view),
+	}
+	if keep > 0 {
+		statements = append(statements, fmt.Sprintf(`SELECT add_retention_policy('%s', drop_after => %s)`, view, pgInterval(keep)))
+	}
+	return statements
+}
+
+// bucketWidth returns the calendar width of an interval's buckets, so that
+// daily buckets stay aligned to local midnight across DST changes
+func bucketWidth(interval models.Interval) string {
+	switch interval {
+	case models.Interval1d:
+		return "1 day"
+	case models.Interval1h, models.Interval4h:
+		return fmt.Sprintf("%d hours", interval.Duration()/time.Hour)
+	default:
+		return fmt.Sprintf("%d minutes", interval.Duration()/time.Minute)
+	}
+}
+
+// pgInterval formats a duration as a Postgres interval literal
+func pgInterval(d time.Duration) string {
+	return fmt.Sprintf("INTERVAL '%d seconds'", int64(d/time.Second))
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+func TestAggregateStatements(t *testing.T) {
+	statements := aggregateStatements(models.Interval4h, "America/New_York")
+	if len(statements) != 2 {
+		t.Fatalf("Expected a view and a refresh policy, got %d statements", len(statements))
+	}
+
+	view := statements[0]
+	for _, want := range []string{
+		"CREATE MATERIALIZED VIEW IF NOT EXISTS candles_4h",
+		"time_bucket(INTERVAL '4 hours', timestamp, 'America/New_York') AS bucket",
+		`WHERE "interval" = '1m'`,
+	} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected view to contain %q, got %s", want, view)
+		}
+	}
+
+	policy := statements[1]
+	if !strings.Contains(policy, "end_offset => INTERVAL '14400 seconds'") || !strings.Contains(policy, "schedule_interval => INTERVAL '3600 seconds'") {
+		t.Errorf("Unexpected refresh policy %s", policy)
+	}
+}
+
+func TestCheckTimescaleVersion(t *testing.T) {
+	for _, version := range []string{"2.11.0", "2.14.2", "2.15.0-dev", "3.0.0"} {
+		if err := checkTimescaleVersion(version); err != nil {
+			t.Errorf("Expected %s to be accepted, got %v", version, err)
+		}
+	}
+	for _, version := range []string{"2.10.3", "1.7.5", "", "latest"} {
+		if err := checkTimescaleVersion(version); err == nil {
+			t.Errorf("Expected %q to be rejected", version)
+		}
+	}
+}
+
+func TestRetentionStatements(t *testing.T) {
+	statements := retentionStatements(models.Interval1h, 2*24*time.Hour)
+	if len(statements) != 2 || !strings.Contains(statements[0], "remove_retention_policy('candles_1h', if_exists => true)") ||
+		!strings.Contains(statements[1], "add_retention_policy('candles_1h', drop_after => INTERVAL '172800 seconds')") {
+		t.Errorf("Unexpected retention statements %v", statements)
+	}
+
+	// Without a rule a policy of an earlier configuration is removed
+	if statements := retentionStatements(models.Interval1d, 0); len(statements) != 1 {
+		t.Errorf("Expected only the old policy to be removed, got %v", statements)
+	}
+}
+
+func TestBucketWidth(t *testing.T) {
+	expected := map[models.Interval]string{
+		models.Interval5m:  "5 minutes",
+		models.Interval15m: "15 minutes",
+		models.Interval1h:  "1 hours",
+		models.Interval1d:  "1 day",
+	}
+	for interval, want := range expected {
+		if got := bucketWidth(interval); got != want {
+			t.Errorf("Expected %s buckets of %q, got %q", interval, want, got)
+		}
+	}
+	if got := pgInterval(7 * 24 * time.Hour); got != "INTERVAL '604800 seconds'" {
+		t.Errorf("Unexpected interval literal %s", got)
+	}
+}
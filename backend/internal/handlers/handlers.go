@@ -2,29 +2,45 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"stock-market-websocket/internal/models"
 	"stock-market-websocket/internal/services"
+	"stock-market-websocket/internal/source"
+	"stock-market-websocket/internal/sse"
+	"stock-market-websocket/internal/store"
 	"stock-market-websocket/internal/websocket"
 )
 
+const (
+	// defaultCandleLimit is the page size of candle requests without a limit
+	defaultCandleLimit = 1000
+	// maxCandleLimit caps the page size of candle requests
+	maxCandleLimit = 5000
+)
+
 // Handler struct holds dependencies for HTTP handlers
 type Handler struct {
 	candleService *services.CandleService
 	symbols       []string
-	finnhubClient *websocket.FinnhubClient
+	tradeSource   source.TradeSource
 	clientManager *websocket.ClientManager
+	streamHub     *sse.Hub
 	startTime     time.Time
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(candleService *services.CandleService, symbols []string, finnhubClient *websocket.FinnhubClient, clientManager *websocket.ClientManager) *Handler {
+func NewHandler(candleService *services.CandleService, symbols []string, tradeSource source.TradeSource, clientManager *websocket.ClientManager, streamHub *sse.Hub) *Handler {
 	return &Handler{
 		candleService: candleService,
 		symbols:       symbols,
-		finnhubClient: finnhubClient,
+		tradeSource:   tradeSource,
 		clientManager: clientManager,
+		streamHub:     streamHub,
 		startTime:     time.Now(),
 	}
 }
@@ -37,7 +53,7 @@ func (h *Handler) HandleHealth(w http.ResponseWriter, _ *http.Request) {
 	response := map[string]interface{}{
 		"status":            "healthy",
 		"time":              time.Now().Format(time.RFC3339),
-		"finnhub_connected": h.finnhubClient != nil && h.finnhubClient.IsConnected(),
+		"finnhub_connected": h.tradeSource != nil && h.tradeSource.Status().Connected,
 		"uptime":            time.Since(h.startTime).String(),
 	}
 	json.NewEncoder(w).Encode(response)
@@ -58,19 +74,21 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	var finnhubConnected bool
-	var lastPingTime time.Time
-
-	if h.finnhubClient != nil {
-		finnhubConnected = h.finnhubClient.IsConnected()
-		lastPingTime = h.finnhubClient.GetLastPingTime()
+	var sourceStatus source.Status
+	if h.tradeSource != nil {
+		sourceStatus = h.tradeSource.Status()
 	}
 
 	response := map[string]interface{}{
-		"finnhub_connected": finnhubConnected,
-		"finnhub_conn_nil":  h.finnhubClient == nil,
+		"source":            sourceStatus.Source,
+		"finnhub_connected": sourceStatus.Connected,
+		"finnhub_conn_nil":  h.tradeSource == nil,
 		"active_clients":    h.clientManager.GetActiveClientsCount(),
-		"last_ping":         lastPingTime.Format(time.RFC3339),
+		"client_stats":      h.clientManager.Stats(),
+		"sse_clients":       h.streamHub.GetActiveClientsCount(),
+		"sse_dropped":       h.streamHub.DroppedMessages(),
+		"candle_writer":     h.candleService.WriterStats(),
+		"last_ping":         sourceStatus.LastPingTime.Format(time.RFC3339),
 		"uptime":            time.Since(h.startTime).String(),
 		"server_start_time": h.startTime.Format(time.RFC3339),
 	}
@@ -88,37 +106,35 @@ func (h *Handler) HandleGetSymbols(w http.ResponseWriter, _ *http.Request) {
 	w.Write(jsonResponse)
 }
 
-// HandleStocksHistory handles requests for all stock history
-func (h *Handler) HandleStocksHistory(w http.ResponseWriter, _ *http.Request) {
-	candles, err := h.candleService.GetAllCandles()
-	if err != nil {
-		http.Error(w, "Failed to retrieve candles", http.StatusInternalServerError)
+// HandleStocksCandles handles requests for specific symbol candles
+func (h *Handler) HandleStocksCandles(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	if symbol == "" {
+		http.Error(w, "Symbol parameter is required", http.StatusBadRequest)
 		return
 	}
 
-	jsonResponse, err := json.Marshal(candles)
+	query, err := parseCandleQuery(r)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonResponse)
-}
+	query.Symbol = symbol
 
-// HandleStocksCandles handles requests for specific symbol candles
-func (h *Handler) HandleStocksCandles(w http.ResponseWriter, r *http.Request) {
-	symbol := r.URL.Query().Get("symbol")
-	if symbol == "" {
-		http.Error(w, "Symbol parameter is required", http.StatusBadRequest)
+	candles, nextCursor, err := h.candleService.GetCandles(query)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	candles, err := h.candleService.GetCandles(symbol)
 	if err != nil {
 		http.Error(w, "Failed to retrieve candles", http.StatusInternalServerError)
 		return
 	}
 
+	if nextCursor != "" {
+		w.Header().Set("X-Next-Cursor", nextCursor)
+	}
+
 	jsonResponse, err := json.Marshal(candles)
 	if err != nil {
 		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
@@ -127,3 +143,58 @@ func (h *Handler) HandleStocksCandles(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonResponse)
 }
+
+// parseCandleQuery reads the interval, from, to, limit, cursor and order
+// parameters of a candle request
+func parseCandleQuery(r *http.Request) (models.CandleQuery, error) {
+	params := r.URL.Query()
+	query := models.CandleQuery{Interval: models.Interval1m}
+
+	if value := params.Get("interval"); value != "" {
+		interval, err := models.ParseInterval(value)
+		if err != nil {
+			return query, err
+		}
+		query.Interval = interval
+	}
+
+	var err error
+	if query.From, err = parseTime(params.Get("from")); err != nil {
+		return query, fmt.Errorf("invalid from: %w", err)
+	}
+	if query.To, err = parseTime(params.Get("to")); err != nil {
+		return query, fmt.Errorf("invalid to: %w", err)
+	}
+
+	query.Limit = defaultCandleLimit
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit %q", value)
+		}
+		query.Limit = min(limit, maxCandleLimit)
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("invalid order %q, expected asc or desc", params.Get("order"))
+	}
+
+	query.Cursor = params.Get("cursor")
+	return query, nil
+}
+
+// parseTime accepts RFC 3339 timestamps or Unix seconds. An empty value
+// yields the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCandleQuery_Limit(t *testing.T) {
+	for _, test := range []struct {
+		url   string
+		limit int
+	}{
+		{"/stocks-candles?symbol=AAPL", defaultCandleLimit},
+		{"/stocks-candles?symbol=AAPL&limit=10", 10},
+		{"/stocks-candles?symbol=AAPL&limit=100000", maxCandleLimit},
+	} {
+		query, err := parseCandleQuery(httptest.NewRequest("GET", test.url, nil))
+		if err != nil {
+			t.Fatalf("parseCandleQuery(%q) failed: %v", test.url, err)
+		}
+		if query.Limit != test.limit {
+			t.Errorf("parseCandleQuery(%q) limit = %d, want %d", test.url, query.Limit, test.limit)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"stock-market-websocket/internal/models"
+)
+
+// historyFlushEvery is the number of candles written between flushes
+const historyFlushEvery = 500
+
+// HandleStocksHistory streams stored candles grouped by symbol. The default
+// format is a JSON object of symbol to candles; format=ndjson writes one
+// candle per line.
+func (h *Handler) HandleStocksHistory(w http.ResponseWriter, r *http.Request) {
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var writer historyWriter
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		writer = &jsonHistoryWriter{w: w}
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writer = &ndjsonHistoryWriter{encoder: json.NewEncoder(w)}
+	default:
+		http.Error(w, "format must be json or ndjson", http.StatusBadRequest)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	written := 0
+	err = h.candleService.StreamCandles(query, func(candle *models.Candle) error {
+		if err := writer.WriteCandle(candle); err != nil {
+			return err
+		}
+		written++
+		if written%historyFlushEvery == 0 {
+			controller.Flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		// Once the body has started the status can no longer change, so the
+		// response is left unterminated for the client to detect
+		if written == 0 {
+			http.Error(w, "Failed to retrieve candles", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Failed to stream candle history after %d candles: %v", written, err)
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Printf("Failed to finish candle history: %v", err)
+	}
+}
+
+// parseHistoryQuery reads the symbols, interval, from and to parameters of a
+// history request. Symbols may be repeated or comma separated.
+func parseHistoryQuery(r *http.Request) (models.HistoryQuery, error) {
+	params := r.URL.Query()
+	query := models.HistoryQuery{Interval: models.Interval1m}
+
+	for _, value := range append(params["symbol"], params["symbols"]...) {
+		for _, symbol := range strings.Split(value, ",") {
+			if symbol = strings.TrimSpace(symbol); symbol != "" {
+				query.Symbols = append(query.Symbols, symbol)
+			}
+		}
+	}
+
+	if value := params.Get("interval"); value != "" {
+		interval, err := models.ParseInterval(value)
+		if err != nil {
+			return query, err
+		}
+		query.Interval = interval
+	}
+
+	var err error
+	if query.From, err = parseTime(params.Get("from")); err != nil {
+		return query, fmt.Errorf("invalid from: %w", err)
+	}
+	if query.To, err = parseTime(params.Get("to")); err != nil {
+		return query, fmt.Errorf("invalid to: %w", err)
+	}
+	return query, nil
+}
+
+// historyWriter writes a stream of candles ordered by symbol
+type historyWriter interface {
+	WriteCandle(candle *models.Candle) error
+	Close() error
+}
+
+// jsonHistoryWriter writes {"AAPL":[...],"MSFT":[...]} incrementally
+type jsonHistoryWriter struct {
+	w       io.Writer
+	symbol  string
+	started bool
+}
+
+// WriteCandle appends a candle, opening a new symbol array when needed
+func (jw *jsonHistoryWriter) WriteCandle(candle *models.Candle) error {
+	data, err := json.Marshal(candle)
+	if err != nil {
+		return err
+	}
+
+	prefix := ","
+	if !jw.started || candle.Symbol != jw.symbol {
+		prefix = "],"
+		if !jw.started {
+			prefix = "{"
+		}
+		key, _ := json.Marshal(candle.Symbol)
+		prefix += string(key) + ":["
+	}
+
+	jw.started = true
+	jw.symbol = candle.Symbol
+	if _, err := io.WriteString(jw.w, prefix); err != nil {
+		return err
+	}
+	_, err = jw.w.Write(data)
+	return err
+}
+
+// Close terminates the JSON object
+func (jw *jsonHistoryWriter) Close() error {
+	if !jw.started {
+		_, err := io.WriteString(jw.w, "{}")
+		return err
+	}
+	_, err := io.WriteString(jw.w, "]}")
+	return err
+}
+
+// ndjsonHistoryWriter writes one candle per line
+type ndjsonHistoryWriter struct {
+	encoder *json.Encoder
+}
+
+// WriteCandle writes a candle followed by a newline
+func (nw *ndjsonHistoryWriter) WriteCandle(candle *models.Candle) error {
+	return nw.encoder.Encode(candle)
+}
+
+// Close is a no-op for NDJSON
+func (nw *ndjsonHistoryWriter) Close() error {
+	return nil
+}
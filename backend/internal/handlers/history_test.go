@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+func TestJSONHistoryWriter_GroupsBySymbol(t *testing.T) {
+	var body strings.Builder
+	writer := &jsonHistoryWriter{w: &body}
+
+	now := time.Date(2024, 6, 3, 13, 30, 0, 0, time.UTC)
+	for _, candle := range []models.Candle{
+		{Symbol: "AAPL", Close: 1, Timestamp: now},
+		{Symbol: "AAPL", Close: 2, Timestamp: now.Add(time.Minute)},
+		{Symbol: "MSFT", Close: 3, Timestamp: now},
+	} {
+		if err := writer.WriteCandle(&candle); err != nil {
+			t.Fatalf("WriteCandle failed: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var grouped map[string][]models.Candle
+	if err := json.Unmarshal([]byte(body.String()), &grouped); err != nil {
+		t.Fatalf("Streamed history is not valid JSON: %v\n%s", err, body.String())
+	}
+	if len(grouped["AAPL"]) != 2 || len(grouped["MSFT"]) != 1 {
+		t.Errorf("Unexpected grouping: %v", grouped)
+	}
+}
+
+func TestJSONHistoryWriter_Empty(t *testing.T) {
+	var body strings.Builder
+	writer := &jsonHistoryWriter{w: &body}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if body.String() != "{}" {
+		t.Errorf("Expected empty object, got %q", body.String())
+	}
+}
@@ -0,0 +1,84 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+)
+
+// AdvisoryLock is a Postgres session-level advisory lock. It is held on a
+// dedicated connection, so it is released by the server as soon as the
+// holder's session ends, including when the process dies.
+type AdvisoryLock struct {
+	db    *sql.DB
+	key   int64
+	conn  *sql.Conn
+	mutex sync.Mutex
+}
+
+// NewAdvisoryLock creates a lock on the given advisory lock key
+func NewAdvisoryLock(db *sql.DB, key int64) *AdvisoryLock {
+	return &AdvisoryLock{db: db, key: key}
+}
+
+// TryAcquire takes the lock if no other session holds it
+func (l *AdvisoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.conn == nil {
+		conn, err := l.db.Conn(ctx)
+		if err != nil {
+			return false, err
+		}
+		l.conn = conn
+	}
+
+	var acquired bool
+	if err := l.conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		l.closeConn()
+		return false, err
+	}
+	return acquired, nil
+}
+
+// Check confirms the session holding the lock is still alive
+func (l *AdvisoryLock) Check(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.conn == nil {
+		return errors.New("lock connection closed")
+	}
+	if err := l.conn.PingContext(ctx); err != nil {
+		l.closeConn()
+		return err
+	}
+	return nil
+}
+
+// Release unlocks and returns the connection to the pool
+func (l *AdvisoryLock) Release(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	l.closeConn()
+	return err
+}
+
+// closeConn discards the lock connection. The caller must hold l.mutex.
+func (l *AdvisoryLock) closeConn() {
+	l.conn.Raw(func(interface{}) error {
+		// Returning ErrBadConn keeps the session, and with it any lock, from
+		// being reused by the pool
+		return driver.ErrBadConn
+	})
+	l.conn.Close()
+	l.conn = nil
+}
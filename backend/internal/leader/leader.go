@@ -0,0 +1,88 @@
+package leader
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Lock is a lock held by at most one node at a time
+type Lock interface {
+	// TryAcquire takes the lock if it is free
+	TryAcquire(ctx context.Context) (bool, error)
+	// Check returns an error once the lock may no longer be held
+	Check(ctx context.Context) error
+	// Release gives the lock up
+	Release(ctx context.Context) error
+}
+
+// Elector campaigns for leadership so that only one replica ingests trades
+type Elector struct {
+	lock     Lock
+	interval time.Duration
+	leader   atomic.Bool
+}
+
+// NewElector creates an elector trying the lock once per interval
+func NewElector(lock Lock, interval time.Duration) *Elector {
+	return &Elector{lock: lock, interval: interval}
+}
+
+// IsLeader reports whether this node currently holds leadership
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns until ctx is done, calling elected when leadership is gained
+// and lost when the lock can no longer be confirmed. A check that does not
+// answer within the interval counts as lost leadership, since the server may
+// already have released the lock to another node. Leadership is released
+// when ctx is done.
+func (e *Elector) Run(ctx context.Context, elected func(), lost func()) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if e.leader.Load() {
+			if err := e.check(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Lost leadership: %v", err)
+				e.leader.Store(false)
+				lost()
+			}
+		} else {
+			acquired, err := e.tryAcquire(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to campaign for leadership: %v", err)
+			}
+			if acquired {
+				log.Printf("Elected leader")
+				e.leader.Store(true)
+				elected()
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if e.leader.Swap(false) {
+				e.lock.Release(context.Background())
+			}
+			return
+		}
+	}
+}
+
+// check confirms the lock is still held within one interval
+func (e *Elector) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+	return e.lock.Check(ctx)
+}
+
+// tryAcquire campaigns for the lock within one interval
+func (e *Elector) tryAcquire(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+	return e.lock.TryAcquire(ctx)
+}
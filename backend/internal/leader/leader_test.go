@@ -0,0 +1,154 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLock is a lock shared by electors in the same test
+type fakeLock struct {
+	mutex  *sync.Mutex
+	holder **fakeLock
+	broken bool
+	hung   bool
+}
+
+func (l *fakeLock) TryAcquire(context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if *l.holder == nil {
+		*l.holder = l
+	}
+	return *l.holder == l, nil
+}
+
+func (l *fakeLock) Check(ctx context.Context) error {
+	l.mutex.Lock()
+	hung := l.hung
+	l.mutex.Unlock()
+	if hung {
+		// The server is unreachable, so only the deadline ends the check
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.broken {
+		// The session died, so the server released the lock
+		*l.holder = nil
+		return errors.New("connection lost")
+	}
+	return nil
+}
+
+func (l *fakeLock) Release(context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if *l.holder == l {
+		*l.holder = nil
+	}
+	return nil
+}
+
+func (l *fakeLock) breakSession() {
+	l.mutex.Lock()
+	l.broken = true
+	l.mutex.Unlock()
+}
+
+func (l *fakeLock) hang() {
+	l.mutex.Lock()
+	l.hung = true
+	l.mutex.Unlock()
+}
+
+// waitFor polls until condition holds
+func waitFor(t *testing.T, condition func() bool, what string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestElector_FailsOverWhenLeaderDies(t *testing.T) {
+	var mutex sync.Mutex
+	var holder *fakeLock
+	firstLock := &fakeLock{mutex: &mutex, holder: &holder}
+	secondLock := &fakeLock{mutex: &mutex, holder: &holder}
+
+	first := NewElector(firstLock, 10*time.Millisecond)
+	second := NewElector(secondLock, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	firstLost := make(chan struct{})
+	go first.Run(ctx, func() {}, func() { close(firstLost) })
+	waitFor(t, first.IsLeader, "first elector to lead")
+
+	secondElected := make(chan struct{})
+	go second.Run(ctx, func() { close(secondElected) }, func() {})
+	time.Sleep(50 * time.Millisecond)
+	if second.IsLeader() {
+		t.Fatal("Expected only one leader")
+	}
+
+	firstLock.breakSession()
+	<-firstLost
+	<-secondElected
+	if first.IsLeader() || !second.IsLeader() {
+		t.Errorf("Expected leadership to move to the second elector")
+	}
+}
+
+func TestElector_LosesLeadershipWhenCheckHangs(t *testing.T) {
+	var mutex sync.Mutex
+	var holder *fakeLock
+	lock := &fakeLock{mutex: &mutex, holder: &holder}
+	elector := NewElector(lock, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lost := make(chan struct{})
+	go elector.Run(ctx, func() {}, func() { close(lost) })
+	waitFor(t, elector.IsLeader, "elector to lead")
+
+	lock.hang()
+	select {
+	case <-lost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a check without an answer to lose leadership")
+	}
+}
+
+func TestElector_ReleasesLeadershipOnShutdown(t *testing.T) {
+	var mutex sync.Mutex
+	var holder *fakeLock
+	lock := &fakeLock{mutex: &mutex, holder: &holder}
+	elector := NewElector(lock, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		elector.Run(ctx, func() {}, func() {})
+		close(done)
+	}()
+	waitFor(t, elector.IsLeader, "elector to lead")
+
+	cancel()
+	<-done
+	mutex.Lock()
+	defer mutex.Unlock()
+	if holder != nil || elector.IsLeader() {
+		t.Error("Expected the lock to be released")
+	}
+}
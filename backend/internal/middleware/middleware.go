@@ -8,7 +8,8 @@ func CORS(next http.HandlerFunc) http.HandlerFunc {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -1,24 +1,128 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
 // Candle represents a candlestick data point
 type Candle struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
-	Symbol    string    `json:"symbol"`
+	Symbol    string    `json:"symbol" gorm:"uniqueIndex:uniq_candles_symbol_interval_timestamp"`
+	Interval  Interval  `json:"interval" gorm:"uniqueIndex:uniq_candles_symbol_interval_timestamp;type:varchar(8);not null;default:'1m'"`
 	Open      float64   `json:"open"`
 	High      float64   `json:"high"`
 	Low       float64   `json:"low"`
 	Close     float64   `json:"close"`
 	Volume    int64     `json:"volume"`
-	Timestamp time.Time `json:"timestamp"`
+	Timestamp time.Time `json:"timestamp" gorm:"uniqueIndex:uniq_candles_symbol_interval_timestamp"`
+}
+
+// Interval represents the timeframe of a candle
+type Interval string
+
+const (
+	Interval1m  Interval = "1m"
+	Interval5m  Interval = "5m"
+	Interval15m Interval = "15m"
+	Interval1h  Interval = "1h"
+	Interval4h  Interval = "4h"
+	Interval1d  Interval = "1d"
+)
+
+// Intervals lists all supported intervals from finest to coarsest
+var Intervals = []Interval{Interval1m, Interval5m, Interval15m, Interval1h, Interval4h, Interval1d}
+
+// ParseInterval validates an interval name
+func ParseInterval(s string) (Interval, error) {
+	for _, interval := range Intervals {
+		if string(interval) == s {
+			return interval, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported interval %q", s)
+}
+
+// Duration returns the nominal length of the interval
+func (i Interval) Duration() time.Duration {
+	switch i {
+	case Interval1m:
+		return time.Minute
+	case Interval5m:
+		return 5 * time.Minute
+	case Interval15m:
+		return 15 * time.Minute
+	case Interval1h:
+		return time.Hour
+	case Interval4h:
+		return 4 * time.Hour
+	case Interval1d:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// BucketStart returns the start of the bucket containing t. Buckets of an
+// hour or longer follow the wall clock of loc so that hourly and daily
+// candles start on local hours and midnights across DST changes.
+func (i Interval) BucketStart(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	d := i.Duration()
+	if d < time.Hour {
+		return local.Truncate(d)
+	}
+
+	if i == Interval1d {
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	}
+	hours := int(d / time.Hour)
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour()/hours*hours, 0, 0, 0, loc)
+}
+
+// BucketEnd returns the end of the bucket starting at start, which is the
+// start of the next bucket. Buckets of an hour or longer end on the wall
+// clock of start's location, so across a DST change they are an hour longer
+// or shorter than their duration.
+func (i Interval) BucketEnd(start time.Time) time.Time {
+	d := i.Duration()
+	if d < time.Hour {
+		return start.Add(d)
+	}
+
+	if i == Interval1d {
+		return start.AddDate(0, 0, 1)
+	}
+	hours := int(d / time.Hour)
+	return time.Date(start.Year(), start.Month(), start.Day(), start.Hour()+hours, 0, 0, 0, start.Location())
+}
+
+// CandleQuery selects a page of stored candles for one symbol and interval.
+// From is inclusive and To is exclusive; zero values leave the range open.
+type CandleQuery struct {
+	Symbol     string
+	Interval   Interval
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Cursor     string
+	Descending bool
+}
+
+// HistoryQuery selects stored candles across symbols. An empty Symbols list
+// selects every symbol; From is inclusive and To is exclusive.
+type HistoryQuery struct {
+	Symbols  []string
+	Interval Interval
+	From     time.Time
+	To       time.Time
 }
 
 // TempCandle represents a temporary candle being built
 type TempCandle struct {
 	Symbol     string    `json:"symbol"`
+	Interval   Interval  `json:"interval"`
 	OpenTime   time.Time `json:"open_time"`
 	OpenPrice  float64   `json:"open_price"`
 	HighPrice  float64   `json:"high_price"`
@@ -36,18 +140,101 @@ type FinnhubMessage struct {
 
 // TradeData represents individual trade data from Finnhub
 type TradeData struct {
-	Symbol    string  `json:"s"`
-	Price     float64 `json:"p"`
-	Volume    int64   `json:"v"`
-	Timestamp int64   `json:"t"`
+	Symbol     string   `json:"s"`
+	Price      float64  `json:"p"`
+	Volume     int64    `json:"v"`
+	Timestamp  int64    `json:"t"`
+	Conditions []string `json:"c,omitempty"`
 }
 
-// BroadcastMessage represents a message to be broadcast to clients
+// Trade represents a raw trade stored in the database
+type Trade struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Symbol     string    `json:"symbol" gorm:"index:idx_trades_symbol_timestamp"`
+	Price      float64   `json:"price"`
+	Volume     int64     `json:"volume"`
+	Timestamp  time.Time `json:"timestamp" gorm:"index:idx_trades_symbol_timestamp"`
+	Conditions string    `json:"conditions"`
+}
+
+// BroadcastMessage represents a message to be broadcast to clients. Seq
+// increases by one with every update of the candle's symbol, across all
+// intervals, and starts again at 1 with every Epoch, which identifies the run
+// of the ingest node. A subscriber of one interval therefore sees gaps that
+// do not mean a closed candle was missed.
 type BroadcastMessage struct {
 	UpdateType UpdateType `json:"update_type"`
 	Candle     *Candle    `json:"candle"`
+	Seq        uint64     `json:"seq"`
+	Epoch      int64      `json:"epoch,omitempty"`
+
+	// Set by edge nodes when updates of the symbol were missed, so that its
+	// subscribers get a reset snapshot instead of the update
+	Resync bool `json:"-"`
 }
 
+// Snapshot is the state of a symbol and interval sent right after a client
+// subscribes. Updates with a Seq up to and including the snapshot's Seq are
+// already reflected in it, and Epoch is the run Seq belongs to. Reset is set
+// when a resume could not be served and the client must replace its local
+// candles.
+type Snapshot struct {
+	Type     string   `json:"type"`
+	Symbol   string   `json:"symbol"`
+	Interval Interval `json:"interval"`
+	Seq      uint64   `json:"seq"`
+	Epoch    int64    `json:"epoch,omitempty"`
+	Candles  []Candle `json:"candles"`
+	Live     *Candle  `json:"live,omitempty"`
+	Reset    bool     `json:"reset,omitempty"`
+}
+
+// SnapshotType is the type of snapshot frames
+const SnapshotType = "snapshot"
+
+// Subscription is a symbol and interval a client receives updates for
+type Subscription struct {
+	Symbol   string   `json:"symbol"`
+	Interval Interval `json:"interval"`
+}
+
+// ClientRequest is a message sent by a WebSocket client, e.g.
+// {"action":"subscribe","symbols":["AAPL","MSFT"],"interval":"1m"}.
+// Resume requests carry the last seq seen per symbol with the epoch of those
+// seqs, or a time in Unix milliseconds to replay closed candles from.
+type ClientRequest struct {
+	Action   string            `json:"action"`
+	Symbols  []string          `json:"symbols,omitempty"`
+	Interval Interval          `json:"interval,omitempty"`
+	Seqs     map[string]uint64 `json:"seqs,omitempty"`
+	Epoch    int64             `json:"epoch,omitempty"`
+	Since    int64             `json:"since,omitempty"`
+}
+
+// Client request actions
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+	ActionResume      = "resume"
+	ActionList        = "list"
+)
+
+// ControlMessage acknowledges a client request or reports an error
+type ControlMessage struct {
+	Type          string         `json:"type"`
+	Action        string         `json:"action,omitempty"`
+	Symbols       []string       `json:"symbols,omitempty"`
+	Interval      Interval       `json:"interval,omitempty"`
+	Subscriptions []Subscription `json:"subscriptions,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// Control message types
+const (
+	ControlAck   = "ack"
+	ControlError = "error"
+)
+
 // UpdateType represents the type of update
 type UpdateType string
 
@@ -61,10 +248,54 @@ func (Candle) TableName() string {
 	return "candles"
 }
 
+// TableName specifies the table name for Trade model
+func (Trade) TableName() string {
+	return "trades"
+}
+
+// ToTrade converts TradeData to a Trade row
+func (td *TradeData) ToTrade() *Trade {
+	return &Trade{
+		Symbol:     td.Symbol,
+		Price:      td.Price,
+		Volume:     td.Volume,
+		Timestamp:  time.UnixMilli(td.Timestamp),
+		Conditions: strings.Join(td.Conditions, ","),
+	}
+}
+
+// NewRollup creates an empty higher-timeframe candle for a bucket
+func NewRollup(symbol string, interval Interval, bucket time.Time) *TempCandle {
+	return &TempCandle{
+		Symbol:    symbol,
+		Interval:  interval,
+		OpenTime:  bucket,
+		CloseTime: interval.BucketEnd(bucket),
+	}
+}
+
+// Merge folds a finer candle into a higher-timeframe candle
+func (tc *TempCandle) Merge(candle *Candle) {
+	if tc.Volume == 0 && tc.OpenPrice == 0 {
+		tc.OpenPrice = candle.Open
+		tc.HighPrice = candle.High
+		tc.LowPrice = candle.Low
+	}
+	if candle.High > tc.HighPrice {
+		tc.HighPrice = candle.High
+	}
+	if candle.Low < tc.LowPrice {
+		tc.LowPrice = candle.Low
+	}
+	tc.ClosePrice = candle.Close
+	tc.Volume += candle.Volume
+}
+
 // ToCandle converts TempCandle to Candle
 func (tc *TempCandle) ToCandle() *Candle {
 	return &Candle{
 		Symbol:    tc.Symbol,
+		Interval:  tc.Interval,
 		Open:      tc.OpenPrice,
 		High:      tc.HighPrice,
 		Low:       tc.LowPrice,
@@ -0,0 +1,106 @@
+package recorder
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/models"
+)
+
+// dbBatchSize is the maximum number of trades inserted in one statement
+const dbBatchSize = 500
+
+// DBRecorder inserts trades into the trades table in batches
+type DBRecorder struct {
+	db      *gorm.DB
+	queue   chan models.TradeData
+	dropped atomic.Int64
+	done    chan struct{}
+
+	// Record holds it while it sends so that Close cannot close the queue
+	closeMutex sync.Mutex
+	closed     bool
+}
+
+// NewDBRecorder creates a recorder writing into the trades table
+func NewDBRecorder(db *gorm.DB) *DBRecorder {
+	r := &DBRecorder{
+		db:    db,
+		queue: make(chan models.TradeData, queueSize),
+		done:  make(chan struct{}),
+	}
+	go r.run()
+
+	log.Printf("Recording trades to the trades table")
+	return r
+}
+
+// Record queues a trade for insertion. Trades are dropped if the queue is full
+// or the recorder is closed.
+func (r *DBRecorder) Record(trade models.TradeData) {
+	r.closeMutex.Lock()
+	defer r.closeMutex.Unlock()
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.queue <- trade:
+	default:
+		if r.dropped.Add(1)%1000 == 1 {
+			log.Printf("Tick recorder queue full, %d trades dropped so far", r.dropped.Load())
+		}
+	}
+}
+
+// Close inserts any queued trades
+func (r *DBRecorder) Close() error {
+	r.closeMutex.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.closeMutex.Unlock()
+	<-r.done
+	return nil
+}
+
+// run collects trades into batches and inserts them every second or when
+// a batch is full
+func (r *DBRecorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	batch := make([]*models.Trade, 0, dbBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.db.CreateInBatches(batch, dbBatchSize).Error; err != nil {
+			log.Printf("Failed to record %d trades: %v", len(batch), err)
+		}
+		batch = make([]*models.Trade, 0, dbBatchSize)
+	}
+
+	for {
+		select {
+		case trade, ok := <-r.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, trade.ToTrade())
+			if len(batch) >= dbBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package recorder
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// FileRecorder appends trades to gzip-compressed NDJSON files that are
+// rotated on a fixed wall-clock interval
+type FileRecorder struct {
+	dir     string
+	rotate  time.Duration
+	queue   chan models.TradeData
+	dropped atomic.Int64
+	done    chan struct{}
+
+	// Held by Record while it sends so that Close cannot close the queue
+	// under it; trades recorded after Close are dropped
+	closeMutex sync.Mutex
+	closed     bool
+
+	// Owned by the writer goroutine
+	file        *os.File
+	gzipWriter  *gzip.Writer
+	encoder     *json.Encoder
+	periodStart time.Time
+}
+
+// NewFileRecorder creates a recorder writing into dir, starting a new file
+// every rotate interval
+func NewFileRecorder(dir string, rotate time.Duration) (*FileRecorder, error) {
+	if rotate <= 0 {
+		return nil, fmt.Errorf("tick recorder rotation interval must be positive, got %s", rotate)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create tick directory: %w", err)
+	}
+
+	r := &FileRecorder{
+		dir:    dir,
+		rotate: rotate,
+		queue:  make(chan models.TradeData, queueSize),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+
+	log.Printf("Recording trades to %s (rotating every %s)", dir, rotate)
+	return r, nil
+}
+
+// Record queues a trade for writing. Trades are dropped if the queue is full
+// or the recorder is closed.
+func (r *FileRecorder) Record(trade models.TradeData) {
+	r.closeMutex.Lock()
+	defer r.closeMutex.Unlock()
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.queue <- trade:
+	default:
+		if r.dropped.Add(1)%1000 == 1 {
+			log.Printf("Tick recorder queue full, %d trades dropped so far", r.dropped.Load())
+		}
+	}
+}
+
+// Close writes any queued trades and closes the current file
+func (r *FileRecorder) Close() error {
+	r.closeMutex.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.closeMutex.Unlock()
+	<-r.done
+	return nil
+}
+
+// run writes queued trades and periodically flushes the compressor so that
+// a crash loses at most one flush interval of data
+func (r *FileRecorder) run() {
+	defer close(r.done)
+
+	flushTicker := time.NewTicker(time.Second)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case trade, ok := <-r.queue:
+			if !ok {
+				r.closeFile()
+				return
+			}
+			if err := r.write(trade); err != nil {
+				log.Printf("Failed to record trade: %v", err)
+			}
+		case <-flushTicker.C:
+			if r.gzipWriter != nil {
+				if err := r.gzipWriter.Flush(); err != nil {
+					log.Printf("Failed to flush tick file: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// write appends a trade to the file for the current rotation period
+func (r *FileRecorder) write(trade models.TradeData) error {
+	periodStart := time.Now().UTC().Truncate(r.rotate)
+	if r.file == nil || !periodStart.Equal(r.periodStart) {
+		r.closeFile()
+		if err := r.openFile(periodStart); err != nil {
+			return err
+		}
+	}
+	return r.encoder.Encode(trade)
+}
+
+// openFile opens the file for a rotation period, appending if it exists
+func (r *FileRecorder) openFile(periodStart time.Time) error {
+	name := filepath.Join(r.dir, fmt.Sprintf("trades-%s.ndjson.gz", periodStart.Format("20060102T150405Z")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open tick file: %w", err)
+	}
+
+	// Appending creates a multi-member gzip stream, which gzip readers handle
+	r.file = file
+	r.gzipWriter = gzip.NewWriter(file)
+	r.encoder = json.NewEncoder(r.gzipWriter)
+	r.periodStart = periodStart
+	return nil
+}
+
+// closeFile finishes the compressed stream and closes the current file
+func (r *FileRecorder) closeFile() {
+	if r.file == nil {
+		return
+	}
+	if err := r.gzipWriter.Close(); err != nil {
+		log.Printf("Failed to finish tick file: %v", err)
+	}
+	if err := r.file.Close(); err != nil {
+		log.Printf("Failed to close tick file: %v", err)
+	}
+	r.file = nil
+	r.gzipWriter = nil
+	r.encoder = nil
+}
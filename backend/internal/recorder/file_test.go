@@ -0,0 +1,79 @@
+package recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+func TestFileRecorder_WritesCompressedNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewFileRecorder(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileRecorder failed: %v", err)
+	}
+
+	recorder.Record(models.TradeData{Symbol: "AAPL", Price: 189.12, Volume: 100, Timestamp: 1717421400000, Conditions: []string{"1", "12"}})
+	recorder.Record(models.TradeData{Symbol: "MSFT", Price: 415.5, Volume: 20, Timestamp: 1717421400250})
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "trades-*.ndjson.gz"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one tick file, got %v (err: %v)", files, err)
+	}
+
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("Failed to open tick file: %v", err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Tick file is not gzip-compressed: %v", err)
+	}
+
+	var trades []models.TradeData
+	scanner := bufio.NewScanner(gzipReader)
+	for scanner.Scan() {
+		var trade models.TradeData
+		if err := json.Unmarshal(scanner.Bytes(), &trade); err != nil {
+			t.Fatalf("Invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		trades = append(trades, trade)
+	}
+
+	if len(trades) != 2 {
+		t.Fatalf("Expected 2 trades, got %d", len(trades))
+	}
+	if trades[0].Symbol != "AAPL" || len(trades[0].Conditions) != 2 {
+		t.Errorf("Expected AAPL trade with conditions, got %+v", trades[0])
+	}
+	if trades[1].Symbol != "MSFT" || trades[1].Timestamp != 1717421400250 {
+		t.Errorf("Expected MSFT trade, got %+v", trades[1])
+	}
+}
+
+func TestFileRecorder_DropsTradesAfterClose(t *testing.T) {
+	recorder, err := NewFileRecorder(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileRecorder failed: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Trades still arriving during shutdown must not panic
+	recorder.Record(models.TradeData{Symbol: "AAPL", Price: 189.12, Volume: 100, Timestamp: 1717421400000})
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Second Close failed: %v", err)
+	}
+}
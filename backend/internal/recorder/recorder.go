@@ -0,0 +1,37 @@
+package recorder
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// queueSize is the number of trades buffered before new trades are dropped
+const queueSize = 10000
+
+// Recorder archives raw trades as they are received
+type Recorder interface {
+	// Record queues a trade for archiving without blocking the caller
+	Record(trade models.TradeData)
+
+	// Close flushes pending trades and releases resources
+	Close() error
+}
+
+// New creates the recorder selected by the configuration. It returns nil
+// when tick recording is disabled.
+func New(cfg *config.Env, db *gorm.DB) (Recorder, error) {
+	switch cfg.TICK_RECORDER {
+	case "":
+		return nil, nil
+	case "file":
+		return NewFileRecorder(cfg.TICK_RECORDER_DIR, cfg.TICK_RECORDER_ROTATE)
+	case "db":
+		return NewDBRecorder(db), nil
+	default:
+		return nil, fmt.Errorf("unknown tick recorder %q", cfg.TICK_RECORDER)
+	}
+}
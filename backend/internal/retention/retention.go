@@ -0,0 +1,304 @@
+package retention
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/store"
+)
+
+// rollupBatchSize is the number of downsampled candles upserted at once
+const rollupBatchSize = 500
+
+// Report describes what a run deleted from one interval or, in a dry run,
+// what it would delete
+type Report struct {
+	Interval models.Interval `json:"interval"`
+	// Candles starting before Cutoff expire
+	Cutoff  time.Time `json:"cutoff"`
+	Expired int64     `json:"expired"`
+	// Expired candles are first rolled up into the next coarser interval
+	// that is kept longer; RolledUp counts the candles this created there
+	RollupInterval models.Interval `json:"rollup_interval,omitempty"`
+	RolledUp       int64           `json:"rolled_up"`
+}
+
+// Engine enforces a retention policy on a candle store. Before candles of an
+// interval are deleted they are rolled up into the next coarser stored
+// interval with a longer retention, so that history loses resolution rather
+// than disappearing. Stores that derive the intervals above one minute expire
+// them with their own policies, so only one-minute candles are enforced there.
+type Engine struct {
+	store      store.CandleStore
+	aggregates bool
+	policy     map[models.Interval]time.Duration
+	intervals  []models.Interval
+	location   *time.Location
+	every      time.Duration
+	done       chan struct{}
+	running    sync.WaitGroup
+	stopOnce   sync.Once
+}
+
+// New creates the engine for RETENTION, or nil when no rule is configured
+func New(cfg *config.Env, candleStore store.CandleStore) (*Engine, error) {
+	policy, err := cfg.Retention()
+	if err != nil {
+		return nil, err
+	}
+	if len(policy) == 0 {
+		return nil, nil
+	}
+
+	// Candles are only rolled up into intervals the service builds
+	intervals := []models.Interval{models.Interval1m}
+	for _, name := range cfg.CANDLE_INTERVALS {
+		if interval, err := models.ParseInterval(name); err == nil && !slices.Contains(intervals, interval) {
+			intervals = append(intervals, interval)
+		}
+	}
+	slices.SortFunc(intervals, func(a, b models.Interval) int {
+		return cmp.Compare(a.Duration(), b.Duration())
+	})
+
+	engine := &Engine{
+		store:     candleStore,
+		policy:    policy,
+		intervals: intervals,
+		location:  cfg.ExchangeLocation(),
+		every:     cfg.RETENTION_INTERVAL,
+		done:      make(chan struct{}),
+	}
+	if aggregateStore, ok := candleStore.(store.AggregateStore); ok {
+		engine.aggregates = aggregateStore.Aggregates()
+	}
+	return engine, nil
+}
+
+// Start enforces the policy now and then every RETENTION_INTERVAL
+func (e *Engine) Start() {
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		ticker := time.NewTicker(e.every)
+		defer ticker.Stop()
+
+		for {
+			reports, err := e.Run(time.Now(), false)
+			if err != nil {
+				log.Printf("Failed to enforce retention: %v", err)
+			}
+			for _, report := range reports {
+				if report.Expired > 0 {
+					log.Printf("Retention deleted %d %s candles before %s and created %d %s candles",
+						report.Expired, report.Interval, report.Cutoff.Format(time.RFC3339), report.RolledUp, report.RollupInterval)
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-e.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops enforcing the policy, waiting for a run in progress to finish
+// so that it is not cut off halfway through a delete
+func (e *Engine) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.done)
+	})
+	e.running.Wait()
+}
+
+// Run enforces the policy as of now, finest interval first so that candles
+// rolled up from one interval are themselves rolled up before they expire.
+// A dry run only reports what would be deleted and created.
+func (e *Engine) Run(now time.Time, dryRun bool) ([]Report, error) {
+	var reports []Report
+	for _, interval := range models.Intervals {
+		keep, ok := e.policy[interval]
+		if !ok || (e.aggregates && interval != models.Interval1m) {
+			continue
+		}
+		report, err := e.enforce(interval, now.Add(-keep), dryRun)
+		if err != nil {
+			return reports, fmt.Errorf("%s candles: %w", interval, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// enforce rolls up and deletes the candles of an interval that start before
+// cutoff. With a rollup interval the cutoff is moved back to the start of
+// its bucket so that only complete buckets are rolled up.
+func (e *Engine) enforce(interval models.Interval, cutoff time.Time, dryRun bool) (Report, error) {
+	report := Report{Interval: interval, RollupInterval: e.rollupInterval(interval)}
+	if report.RollupInterval != "" {
+		cutoff = report.RollupInterval.BucketStart(cutoff, e.location)
+	}
+	report.Cutoff = cutoff
+
+	if report.RollupInterval != "" {
+		var err error
+		report.RolledUp, err = e.rollUp(interval, report.RollupInterval, cutoff, dryRun)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if dryRun {
+		expired, err := e.store.CountBefore(interval, cutoff)
+		report.Expired = expired
+		return report, err
+	}
+
+	deleted, err := e.store.DeleteBefore(interval, cutoff)
+	report.Expired = deleted
+	return report, err
+}
+
+// rollUp builds the candles of coarse that are missing for the buckets of
+// fine candles starting before cutoff and upserts them in batches as they are
+// built, unless in a dry run. Stored coarse candles are kept, since they may
+// have been built from more trades than the remaining fine candles. It
+// returns the number of candles built.
+func (e *Engine) rollUp(fine, coarse models.Interval, cutoff time.Time, dryRun bool) (int64, error) {
+	var (
+		built   int64
+		batch   []*models.Candle
+		current *models.TempCandle
+		stored  *storedBuckets
+	)
+	write := func() error {
+		if !dryRun && len(batch) > 0 {
+			if err := e.store.Upsert(batch); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		rollup := current.ToCandle()
+		current = nil
+
+		found, err := stored.contains(rollup.Timestamp)
+		if err != nil || found {
+			return err
+		}
+		built++
+		batch = append(batch, rollup)
+		if len(batch) >= rollupBatchSize {
+			return write()
+		}
+		return nil
+	}
+
+	err := e.store.Stream(models.HistoryQuery{Interval: fine, To: cutoff}, func(candle *models.Candle) error {
+		if stored == nil || candle.Symbol != stored.query.Symbol {
+			if err := flush(); err != nil {
+				return err
+			}
+			stored = e.storedBuckets(candle.Symbol, coarse, candle.Timestamp, cutoff)
+		}
+
+		bucket := coarse.BucketStart(candle.Timestamp, e.location)
+		if current == nil || !current.OpenTime.Equal(bucket) {
+			if err := flush(); err != nil {
+				return err
+			}
+			current = models.NewRollup(candle.Symbol, coarse, bucket)
+		}
+		current.Merge(candle)
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		err = write()
+	}
+	return built, err
+}
+
+// storedBuckets pages through the stored candles of a symbol and interval
+// between from and to, so that checking which buckets are stored does not
+// load them all at once
+type storedBuckets struct {
+	store  store.CandleStore
+	query  models.CandleQuery
+	page   []models.Candle
+	loaded bool
+}
+
+// storedBuckets returns the stored buckets of a symbol and interval between
+// from and to
+func (e *Engine) storedBuckets(symbol string, interval models.Interval, from, to time.Time) *storedBuckets {
+	return &storedBuckets{
+		store: e.store,
+		query: models.CandleQuery{
+			Symbol:   symbol,
+			Interval: interval,
+			From:     interval.BucketStart(from, e.location),
+			To:       to,
+			Limit:    rollupBatchSize,
+		},
+	}
+}
+
+// contains reports whether a candle starting at bucket is stored. Buckets
+// must be checked in ascending order.
+func (b *storedBuckets) contains(bucket time.Time) (bool, error) {
+	for {
+		for len(b.page) > 0 && b.page[0].Timestamp.Before(bucket) {
+			b.page = b.page[1:]
+		}
+		if len(b.page) > 0 {
+			return b.page[0].Timestamp.Equal(bucket), nil
+		}
+		if b.loaded && b.query.Cursor == "" {
+			return false, nil
+		}
+
+		page, next, err := b.store.Range(b.query)
+		if err != nil {
+			return false, err
+		}
+		b.page, b.query.Cursor, b.loaded = page, next, true
+		if len(page) == 0 {
+			return false, nil
+		}
+	}
+}
+
+// rollupInterval returns the next coarser stored interval that is kept
+// longer than interval, or "" when there is none
+func (e *Engine) rollupInterval(interval models.Interval) models.Interval {
+	if e.aggregates {
+		// Coarser candles are derived rather than stored
+		return ""
+	}
+	keep := e.policy[interval]
+	for _, coarser := range e.intervals {
+		if coarser.Duration() <= interval.Duration() {
+			continue
+		}
+		if coarserKeep, limited := e.policy[coarser]; !limited || coarserKeep > keep {
+			return coarser
+		}
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/store"
+	"stock-market-websocket/internal/store/storetest"
+)
+
+func newTestEngine(t *testing.T, candleStore store.CandleStore, rules ...string) *Engine {
+	t.Helper()
+
+	engine, err := New(&config.Env{
+		EXCHANGE_TIMEZONE:  "UTC",
+		CANDLE_INTERVALS:   []string{"5m", "1h"},
+		RETENTION:          rules,
+		RETENTION_INTERVAL: time.Hour,
+	}, candleStore)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	return engine
+}
+
+func TestEngine_RollsUpBeforeDeleting(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-72 * time.Hour)
+
+	candleStore := store.NewMemory()
+	var candles []*models.Candle
+	for i := range 10 {
+		candles = append(candles, &models.Candle{
+			Symbol:    "AAPL",
+			Interval:  models.Interval1m,
+			Open:      float64(100 + i),
+			High:      float64(101 + i),
+			Low:       float64(99 + i),
+			Close:     float64(100 + i),
+			Volume:    1,
+			Timestamp: old.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	recent := &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Close: 1, Timestamp: now.Add(-time.Hour)}
+	// The first bucket was already built from the live trades
+	built := &models.Candle{Symbol: "AAPL", Interval: models.Interval5m, Open: 1, High: 1, Low: 1, Close: 1, Volume: 99, Timestamp: old}
+	candleStore.Upsert(append(candles, recent, built))
+
+	engine := newTestEngine(t, candleStore, "1m=1d", "5m=30d")
+
+	reports, err := engine.Run(now, true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Expected a report per rule, got %+v", reports)
+	}
+	if report := reports[0]; report.Interval != models.Interval1m || report.Expired != 10 || report.RollupInterval != models.Interval5m || report.RolledUp != 1 {
+		t.Errorf("Unexpected dry-run report %+v", report)
+	}
+	if stored, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m}); len(stored) != 11 {
+		t.Fatalf("Expected a dry run to keep every candle, got %d", len(stored))
+	}
+
+	if _, err := engine.Run(now, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	minutes, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m})
+	if len(minutes) != 1 || minutes[0].Close != 1 {
+		t.Errorf("Expected only the recent minute to remain, got %+v", minutes)
+	}
+
+	rollups, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval5m})
+	if len(rollups) != 2 {
+		t.Fatalf("Expected two 5m candles, got %+v", rollups)
+	}
+	if rollups[0].Volume != 99 {
+		t.Errorf("Expected the stored 5m candle to be kept, got %+v", rollups[0])
+	}
+	if second := rollups[1]; !second.Timestamp.Equal(old.Add(5*time.Minute)) || second.Open != 105 || second.High != 110 || second.Low != 104 || second.Close != 109 || second.Volume != 5 {
+		t.Errorf("Unexpected rolled up candle %+v", second)
+	}
+}
+
+func TestEngine_LeavesAggregatesToTheirPolicies(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-72 * time.Hour)
+
+	candleStore := storetest.NewAggregateMemory()
+	candleStore.Upsert([]*models.Candle{
+		{Symbol: "AAPL", Interval: models.Interval1m, Close: 1, Volume: 1, Timestamp: old},
+		{Symbol: "AAPL", Interval: models.Interval5m, Close: 1, Volume: 1, Timestamp: old},
+	})
+
+	reports, err := newTestEngine(t, candleStore, "1m=1d", "5m=1d", "1h=2y").Run(now, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Interval != models.Interval1m || reports[0].Expired != 1 || reports[0].RollupInterval != "" {
+		t.Errorf("Expected only 1m candles to expire without a rollup, got %+v", reports)
+	}
+	if rollup, _ := candleStore.Latest("AAPL", models.Interval5m); rollup == nil {
+		t.Error("Expected 5m candles to be left to the aggregate policy")
+	}
+}
+
+func TestEngine_RollsUpAcrossBatchesAndPages(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-30 * 24 * time.Hour)
+
+	// More buckets than fit in one upsert batch, every other one already
+	// stored so that the stored buckets span several pages
+	candleStore := store.NewMemory()
+	var candles []*models.Candle
+	for i := range 5 * 2 * rollupBatchSize {
+		timestamp := old.Add(time.Duration(i) * time.Minute)
+		candles = append(candles, &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Open: 1, High: 1, Low: 1, Close: 1, Volume: 1, Timestamp: timestamp})
+		if i%10 == 0 {
+			candles = append(candles, &models.Candle{Symbol: "AAPL", Interval: models.Interval5m, Open: 2, High: 2, Low: 2, Close: 2, Volume: 99, Timestamp: timestamp})
+		}
+	}
+	candleStore.Upsert(candles)
+
+	reports, err := newTestEngine(t, candleStore, "1m=1d").Run(now, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(reports) != 1 || reports[0].RolledUp != rollupBatchSize {
+		t.Fatalf("Expected %d missing 5m candles to be built, got %+v", rollupBatchSize, reports)
+	}
+
+	rollups, _, _ := candleStore.Range(models.CandleQuery{Symbol: "AAPL", Interval: models.Interval5m})
+	if len(rollups) != 2*rollupBatchSize {
+		t.Fatalf("Expected %d 5m candles, got %d", 2*rollupBatchSize, len(rollups))
+	}
+	for i, rollup := range rollups {
+		if stored := i%2 == 0; stored != (rollup.Volume == 99) {
+			t.Fatalf("Expected only missing buckets to be built, got %+v at %d", rollup, i)
+		}
+	}
+}
+
+func TestEngine_RollupInterval(t *testing.T) {
+	engine := newTestEngine(t, store.NewMemory(), "1m=30d", "5m=7d", "1h=2y")
+
+	// 5m is kept for less time than 1m, so minutes roll up into hours
+	if got := engine.rollupInterval(models.Interval1m); got != models.Interval1h {
+		t.Errorf("Expected 1m to roll up into 1h, got %q", got)
+	}
+	// Nothing coarser than 1h is stored
+	if got := engine.rollupInterval(models.Interval1h); got != "" {
+		t.Errorf("Expected 1h to have no rollup interval, got %q", got)
+	}
+}
+
+func TestNew_Policy(t *testing.T) {
+	if engine := newTestEngine(t, store.NewMemory()); engine != nil {
+		t.Error("Expected no engine without rules")
+	}
+
+	for _, rules := range [][]string{{"1m"}, {"2m=1d"}, {"1h=30m"}, {"1d=soon"}} {
+		cfg := &config.Env{RETENTION: rules}
+		if _, err := New(cfg, store.NewMemory()); err == nil {
+			t.Errorf("Expected %v to be rejected", rules)
+		}
+	}
+
+	policy, err := (&config.Env{RETENTION: []string{"1m=30d", " 1h = 2y", "4h=12w", "5m=36h"}}).Retention()
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	day := 24 * time.Hour
+	if policy[models.Interval1m] != 30*day || policy[models.Interval1h] != 730*day || policy[models.Interval4h] != 84*day || policy[models.Interval5m] != 36*time.Hour {
+		t.Errorf("Unexpected policy %v", policy)
+	}
+}
+
+// slowDeleteStore is a memory store whose deletes wait until released
+type slowDeleteStore struct {
+	*store.Memory
+	deleting chan struct{}
+	release  chan struct{}
+}
+
+func (s *slowDeleteStore) DeleteBefore(interval models.Interval, before time.Time) (int64, error) {
+	close(s.deleting)
+	<-s.release
+	return s.Memory.DeleteBefore(interval, before)
+}
+
+func TestEngine_StopWaitsForRunningDelete(t *testing.T) {
+	candleStore := &slowDeleteStore{Memory: store.NewMemory(), deleting: make(chan struct{}), release: make(chan struct{})}
+	engine := newTestEngine(t, candleStore, "1d=30d")
+	engine.Start()
+	<-candleStore.deleting
+
+	stopped := make(chan struct{})
+	go func() {
+		engine.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Expected Stop to wait for the delete in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(candleStore.release)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Stop to return once the delete finished")
+	}
+}
@@ -2,29 +2,82 @@ package services
 
 import (
 	"log"
+	"slices"
 	"sync"
 	"time"
 
-	"gorm.io/gorm"
-
+	"stock-market-websocket/internal/config"
 	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/store"
 )
 
-// CandleService manages candle processing and database operations
+// closeCheckInterval is how often open candles are checked for expiry
+const closeCheckInterval = time.Second
+
+// CandleService manages candle processing and storage
 type CandleService struct {
-	db          *gorm.DB
+	store      store.CandleStore
+	location   *time.Location
+	closeGrace time.Duration
+	intervals  []models.Interval
+	// Set when the store derives the intervals above one minute itself
+	aggregates  bool
 	tempCandles map[string]*models.TempCandle
+	previous    map[string]*models.TempCandle
+	rollups     map[models.Interval]map[string]*models.TempCandle
+	closedUntil map[string]time.Time
+	// The trade clock runs eventLag behind the wall clock. The lag only
+	// shrinks, so that a delayed trade of one symbol does not hold back the
+	// closing of the others.
+	eventLag time.Duration
+	clockSet bool
+	stream   *streamState
+	writer   *candleWriter
+	seeds    sync.WaitGroup
+
+	// epoch identifies this run in the updates it emits; remoteEpoch is the
+	// run of the ingest node an edge node mirrors
+	epoch       int64
+	remoteEpoch int64
+	resyncs     map[string]bool
+
 	mutex       sync.Mutex
 	broadcastCh chan *models.BroadcastMessage
+	done        chan struct{}
+	stopOnce    sync.Once
 }
 
 // NewCandleService creates a new candle service
-func NewCandleService(db *gorm.DB) *CandleService {
-	return &CandleService{
-		db:          db,
+func NewCandleService(candleStore store.CandleStore, cfg *config.Env) *CandleService {
+	cs := &CandleService{
+		store:       candleStore,
+		location:    cfg.ExchangeLocation(),
+		closeGrace:  cfg.CANDLE_CLOSE_GRACE,
 		tempCandles: make(map[string]*models.TempCandle),
+		previous:    make(map[string]*models.TempCandle),
+		rollups:     make(map[models.Interval]map[string]*models.TempCandle),
+		closedUntil: make(map[string]time.Time),
+		stream:      newStreamState(recentCandleCapacity),
+		epoch:       time.Now().UnixNano(),
+		resyncs:     make(map[string]bool),
 		broadcastCh: make(chan *models.BroadcastMessage, 100),
+		done:        make(chan struct{}),
+	}
+	if aggregateStore, ok := candleStore.(store.AggregateStore); ok {
+		cs.aggregates = aggregateStore.Aggregates()
+	}
+	cs.writer = newCandleWriter(candleStore.Upsert, cfg.CANDLE_WRITE_BATCH, cfg.CANDLE_WRITE_INTERVAL, cfg.CANDLE_WRITE_MAX_BACKOFF, cfg.CANDLE_WAL_FILE)
+
+	for _, name := range cfg.CANDLE_INTERVALS {
+		interval, err := models.ParseInterval(name)
+		if err != nil || interval == models.Interval1m {
+			continue
+		}
+		cs.intervals = append(cs.intervals, interval)
+		cs.rollups[interval] = make(map[string]*models.TempCandle)
 	}
+
+	return cs
 }
 
 // GetBroadcastChannel returns the broadcast channel
@@ -32,83 +85,406 @@ func (cs *CandleService) GetBroadcastChannel() chan *models.BroadcastMessage {
 	return cs.broadcastCh
 }
 
-// ProcessTradeData processes trade data and creates/updates candles
+// Start begins closing candles at their bucket boundary and writing them
+// to the database
+func (cs *CandleService) Start() {
+	cs.writer.Start()
+	go cs.closeCandlesOnSchedule()
+}
+
+// Stop stops the candle closing scheduler, closes the candles whose bucket
+// has ended on the trade clock, even within their grace period, and writes
+// the closed candles that are still queued
+func (cs *CandleService) Stop() {
+	cs.stopOnce.Do(func() {
+		close(cs.done)
+	})
+
+	cs.mutex.Lock()
+	eventTime := time.Now().Add(-cs.eventLag)
+	for _, tempCandle := range cs.previous {
+		cs.closeCandle(tempCandle)
+	}
+	for _, tempCandle := range cs.tempCandles {
+		if !eventTime.Before(tempCandle.CloseTime) {
+			cs.closeCandle(tempCandle)
+		}
+	}
+	cs.mutex.Unlock()
+
+	// Rollups started above are seeded with their stored minutes before they
+	// are closed
+	cs.seeds.Wait()
+	cs.mutex.Lock()
+	cs.closeExpiredRollups(eventTime.Add(cs.closeGrace))
+	cs.mutex.Unlock()
+
+	cs.writer.Close()
+}
+
+// WriterStats returns the backlog of closed candles waiting to be written
+func (cs *CandleService) WriterStats() WriterStats {
+	return cs.writer.Stats()
+}
+
+// ProcessTradeData processes trade data and creates/updates candles. A
+// candle stays open for the grace period after its bucket ends, so late
+// trades still count once the next bucket has started; at most two buckets
+// per symbol are open at a time.
 func (cs *CandleService) ProcessTradeData(trade *models.TradeData) {
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
 
 	symbol := trade.Symbol
-	timestamp := time.UnixMilli(trade.Timestamp)
+	tradeTime := time.UnixMilli(trade.Timestamp)
+	openTime := models.Interval1m.BucketStart(tradeTime, cs.location)
 	price := trade.Price
 	volume := trade.Volume
 
-	tempCandle, exists := cs.tempCandles[symbol]
-	if !exists || timestamp.After(tempCandle.CloseTime) {
-		if exists {
-			candle := tempCandle.ToCandle()
-			if err := cs.db.Create(candle).Error; err != nil {
-				log.Printf("Failed to create candle: %v", err)
+	if lag := max(time.Since(tradeTime), 0); !cs.clockSet || lag < cs.eventLag {
+		cs.eventLag = lag
+		cs.clockSet = true
+	}
+
+	if openTime.Before(cs.closedUntil[symbol]) {
+		log.Printf("Dropping late trade for %s in already closed bucket %s", symbol, openTime.Format(time.RFC3339))
+		return
+	}
+
+	tempCandle := cs.tempCandles[symbol]
+	switch {
+	case tempCandle == nil || openTime.After(tempCandle.OpenTime):
+		if tempCandle != nil {
+			// A third bucket closes the oldest one, and the current one is
+			// kept open for late trades while its grace period lasts
+			if previous := cs.previous[symbol]; previous != nil {
+				cs.closeCandle(previous)
+			}
+			if tradeTime.Before(tempCandle.CloseTime.Add(cs.closeGrace)) {
+				cs.previous[symbol] = tempCandle
+				delete(cs.tempCandles, symbol)
 			} else {
-				cs.broadcastCh <- &models.BroadcastMessage{
-					UpdateType: models.Closed,
-					Candle:     candle,
-				}
+				cs.closeCandle(tempCandle)
 			}
 		}
 
 		tempCandle = &models.TempCandle{
 			Symbol:     symbol,
-			OpenTime:   timestamp,
+			Interval:   models.Interval1m,
+			OpenTime:   openTime,
 			OpenPrice:  price,
 			HighPrice:  price,
 			LowPrice:   price,
-			CloseTime:  timestamp.Add(1 * time.Minute),
+			CloseTime:  models.Interval1m.BucketEnd(openTime),
 			ClosePrice: price,
 			Volume:     volume,
 		}
 		cs.tempCandles[symbol] = tempCandle
 
-		cs.broadcastCh <- &models.BroadcastMessage{
-			UpdateType: models.Live,
-			Candle:     tempCandle.ToCandle(),
+		cs.emit(models.Live, tempCandle.ToCandle())
+	case openTime.Equal(tempCandle.OpenTime):
+		updateTempCandle(tempCandle, price, volume)
+		cs.emit(models.Live, tempCandle.ToCandle())
+	default:
+		// A late trade of the previous bucket shows when that bucket closes
+		previous := cs.previous[symbol]
+		if previous == nil || !openTime.Equal(previous.OpenTime) {
+			log.Printf("Dropping late trade for %s in already closed bucket %s", symbol, openTime.Format(time.RFC3339))
+			return
 		}
+		updateTempCandle(previous, price, volume)
+	}
+
+	cs.broadcastLiveRollups(symbol)
+}
+
+// updateTempCandle adds a trade to an open candle
+func updateTempCandle(tempCandle *models.TempCandle, price float64, volume int64) {
+	tempCandle.ClosePrice = price
+	tempCandle.Volume += volume
+	if price > tempCandle.HighPrice {
+		tempCandle.HighPrice = price
+	}
+	if price < tempCandle.LowPrice {
+		tempCandle.LowPrice = price
+	}
+}
+
+// closeCandle persists a finished candle and broadcasts it as closed.
+// The caller must hold cs.mutex.
+func (cs *CandleService) closeCandle(tempCandle *models.TempCandle) {
+	if cs.previous[tempCandle.Symbol] == tempCandle {
+		delete(cs.previous, tempCandle.Symbol)
 	} else {
-		// Update existing temp candle
-		tempCandle.ClosePrice = price
-		tempCandle.Volume += volume
-		if price > tempCandle.HighPrice {
-			tempCandle.HighPrice = price
+		delete(cs.tempCandles, tempCandle.Symbol)
+	}
+	cs.closedUntil[tempCandle.Symbol] = tempCandle.CloseTime
+
+	candle := cs.persistCandle(tempCandle)
+	cs.rollUp(candle)
+}
+
+// persistCandle queues a finished candle of any interval for writing and
+// broadcasts it as closed. Only one-minute candles are written when the
+// store derives the other intervals. The caller must hold cs.mutex.
+func (cs *CandleService) persistCandle(tempCandle *models.TempCandle) *models.Candle {
+	candle := tempCandle.ToCandle()
+	if !cs.aggregates || candle.Interval == models.Interval1m {
+		// The writer gets its own copy since the broadcast candle is shared
+		stored := *candle
+		cs.writer.Enqueue(&stored)
+	}
+
+	cs.emit(models.Closed, candle)
+	return candle
+}
+
+// emit assigns the next sequence number of the candle's symbol, records the
+// update for snapshots and broadcasts it. The first update of a symbol after
+// a promotion is flagged for subscribers to resync. The caller must hold
+// cs.mutex.
+func (cs *CandleService) emit(updateType models.UpdateType, candle *models.Candle) {
+	msg := &models.BroadcastMessage{
+		UpdateType: updateType,
+		Candle:     candle,
+		Seq:        cs.stream.nextSeq(candle.Symbol),
+		Epoch:      cs.epoch,
+		Resync:     cs.resyncs[candle.Symbol],
+	}
+	delete(cs.resyncs, candle.Symbol)
+	cs.stream.record(msg)
+	cs.broadcastCh <- msg
+}
+
+// ApplyRemote records and broadcasts an update built by the ingest node, so
+// that an edge node serves the same snapshots, resumes and sequence numbers.
+// When the ingest node restarted or updates of the symbol were missed, the
+// update is flagged for subscribers to resync.
+func (cs *CandleService) ApplyRemote(msg *models.BroadcastMessage) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	symbol := msg.Candle.Symbol
+	if msg.Epoch != cs.remoteEpoch {
+		if cs.remoteEpoch != 0 {
+			log.Printf("Ingest node restarted, resetting sequence numbers")
+			for seenSymbol := range cs.stream.seqs {
+				cs.resyncs[seenSymbol] = true
+			}
+			cs.stream = newStreamState(recentCandleCapacity)
+		}
+		cs.remoteEpoch = msg.Epoch
+	}
+
+	if last := cs.stream.seqs[symbol]; last > 0 && msg.Seq > last+1 {
+		log.Printf("Missed updates %d to %d of %s, resyncing subscribers", last+1, msg.Seq-1, symbol)
+		cs.stream.skipTo(symbol, msg.Seq)
+		cs.resyncs[symbol] = true
+	}
+
+	if cs.resyncs[symbol] {
+		delete(cs.resyncs, symbol)
+		resync := *msg
+		resync.Resync = true
+		msg = &resync
+	}
+
+	cs.stream.record(msg)
+	cs.broadcastCh <- msg
+}
+
+// Promote switches a node that mirrored the ingest node to emitting its own
+// updates. The mirrored sequence numbers are dropped and a fresh epoch is
+// started, so that snapshots, resumes and live updates all agree on it and
+// subscribers of the symbols seen so far resync on their next update. It is
+// called before Start, once the backplane is no longer applied.
+func (cs *CandleService) Promote() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if cs.remoteEpoch == 0 {
+		return
+	}
+	for symbol := range cs.stream.seqs {
+		cs.resyncs[symbol] = true
+	}
+	cs.stream = newStreamState(recentCandleCapacity)
+	cs.remoteEpoch = 0
+	cs.epoch = max(time.Now().UnixNano(), cs.epoch+1)
+}
+
+// closeCandlesOnSchedule periodically closes candles whose bucket has ended
+func (cs *CandleService) closeCandlesOnSchedule() {
+	ticker := time.NewTicker(closeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			cs.closeExpiredCandles(now)
+		case <-cs.done:
+			return
 		}
-		if price < tempCandle.LowPrice {
-			tempCandle.LowPrice = price
+	}
+}
+
+// closeExpiredCandles closes every candle whose bucket ended more than the
+// grace period ago. Time is measured on the trade clock (wall clock minus the
+// smallest trade delay seen) so that replays of old data close correctly.
+func (cs *CandleService) closeExpiredCandles(now time.Time) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	eventTime := now.Add(-cs.eventLag)
+	// Previous buckets go first so that every symbol's candles close in order
+	for _, tempCandles := range []map[string]*models.TempCandle{cs.previous, cs.tempCandles} {
+		for _, tempCandle := range tempCandles {
+			if !eventTime.Before(tempCandle.CloseTime.Add(cs.closeGrace)) {
+				cs.closeCandle(tempCandle)
+			}
 		}
+	}
+	cs.closeExpiredRollups(eventTime)
+}
+
+// GetCandles retrieves a page of candles and the cursor of the next page,
+// which is empty when there are no more candles
+func (cs *CandleService) GetCandles(query models.CandleQuery) ([]models.Candle, string, error) {
+	return cs.store.Range(query)
+}
+
+// Snapshot returns up to limit closed candles (oldest first) and the live
+// candle of a symbol and interval, along with the sequence number of the
+// last update they reflect
+func (cs *CandleService) Snapshot(symbol string, interval models.Interval, limit int) (*models.Snapshot, error) {
+	key := models.Subscription{Symbol: symbol, Interval: interval}
 
-		cs.broadcastCh <- &models.BroadcastMessage{
-			UpdateType: models.Live,
-			Candle:     tempCandle.ToCandle(),
+	cs.mutex.Lock()
+	seq, candles, live := cs.stream.snapshot(key, limit)
+	epoch := cs.servedEpoch()
+	cs.mutex.Unlock()
+
+	// Older candles come from the database, bounded by the oldest candle in
+	// memory so that candles closed after the snapshot are not included
+	if missing := limit - len(candles); missing > 0 {
+		query := models.CandleQuery{Symbol: symbol, Interval: interval, Limit: missing, Descending: true}
+		switch {
+		case len(candles) > 0:
+			query.To = candles[0].Timestamp
+		case live != nil:
+			query.To = live.Timestamp
+		}
+
+		stored, _, err := cs.GetCandles(query)
+		if err != nil {
+			return nil, err
 		}
+		slices.Reverse(stored)
+		candles = append(stored, candles...)
 	}
+
+	return &models.Snapshot{
+		Type:     models.SnapshotType,
+		Symbol:   symbol,
+		Interval: interval,
+		Seq:      seq,
+		Epoch:    epoch,
+		Candles:  candles,
+		Live:     live,
+	}, nil
 }
 
-// GetCandles retrieves candles for a specific symbol
-func (cs *CandleService) GetCandles(symbol string) ([]models.Candle, error) {
-	var candles []models.Candle
-	err := cs.db.Where("symbol = ?", symbol).Order("timestamp asc").Find(&candles).Error
-	return candles, err
+// servedEpoch returns the epoch of the sequence numbers this node serves:
+// its own, or the ingest node's on an edge node. The caller must hold
+// cs.mutex.
+func (cs *CandleService) servedEpoch() int64 {
+	if cs.remoteEpoch != 0 {
+		return cs.remoteEpoch
+	}
+	return cs.epoch
+}
+
+// Replay returns the updates of a symbol and interval after seq: the closed
+// candles since then, oldest first, followed by the live candle. ok is false
+// when the candles are no longer in memory or seq is from another epoch than
+// the one served, such as from before a restart, in which case the client
+// needs a fresh snapshot.
+func (cs *CandleService) Replay(symbol string, interval models.Interval, epoch int64, seq uint64) ([]models.BroadcastMessage, uint64, bool) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	key := models.Subscription{Symbol: symbol, Interval: interval}
+	if epoch != cs.servedEpoch() {
+		return nil, cs.stream.seqs[symbol], false
+	}
+	updates, current, ok := cs.stream.since(key, seq)
+	for i := range updates {
+		updates[i].Epoch = epoch
+	}
+	return updates, current, ok
 }
 
-// GetAllCandles retrieves all candles grouped by symbol
-func (cs *CandleService) GetAllCandles() (map[string][]models.Candle, error) {
-	var candles []models.Candle
-	err := cs.db.Order("timestamp asc").Find(&candles).Error
+// ReplaySince returns the closed candles of a symbol and interval starting
+// at or after since, oldest first, followed by the live candle, along with
+// the sequence number they reflect. Candles closed recently come from memory
+// since they may still be waiting to be written. ok is false when there are
+// more than limit closed candles or some of them cannot be found.
+func (cs *CandleService) ReplaySince(symbol string, interval models.Interval, since time.Time, limit int) ([]models.BroadcastMessage, uint64, bool, error) {
+	key := models.Subscription{Symbol: symbol, Interval: interval}
+
+	// The sequence number is read before the stored candles so that candles
+	// closing in between are delivered as later updates rather than lost
+	cs.mutex.Lock()
+	seq := cs.stream.seqs[symbol]
+	live := cs.stream.liveCandle(key)
+	recent, trimmed := cs.stream.closedSince(key, since)
+	cs.mutex.Unlock()
+
+	candles, next, err := cs.GetCandles(models.CandleQuery{Symbol: symbol, Interval: interval, From: since, Limit: limit})
 	if err != nil {
-		return nil, err
+		return nil, 0, false, err
+	}
+	if next != "" {
+		return nil, seq, false, nil
 	}
 
-	groupedData := make(map[string][]models.Candle)
-	for _, candle := range candles {
-		groupedData[candle.Symbol] = append(groupedData[candle.Symbol], candle)
+	// Add the candles in memory that are newer than the newest stored one
+	newest := since.Add(-time.Nanosecond)
+	if len(candles) > 0 {
+		newest = candles[len(candles)-1].Timestamp
+	}
+	start := 0
+	for start < len(recent) && !recent[start].Timestamp.After(newest) {
+		start++
+	}
+	if start == 0 && trimmed && len(recent) > 0 && cs.writerBacklog() {
+		// Candles between the stored ones and those in memory may still be
+		// waiting to be written
+		return nil, seq, false, nil
+	}
+	candles = append(candles, recent[start:]...)
+	if len(candles) > limit {
+		return nil, seq, false, nil
 	}
 
-	return groupedData, nil
+	updates := make([]models.BroadcastMessage, 0, len(candles)+1)
+	for i := range candles {
+		updates = append(updates, models.BroadcastMessage{UpdateType: models.Closed, Candle: &candles[i]})
+	}
+	if live != nil && !live.Timestamp.Before(since) {
+		updates = append(updates, models.BroadcastMessage{UpdateType: models.Live, Candle: live, Seq: seq})
+	}
+	return updates, seq, true, nil
+}
+
+// writerBacklog reports whether closed candles are waiting to be written
+func (cs *CandleService) writerBacklog() bool {
+	stats := cs.writer.Stats()
+	return stats.Queued > 0 || stats.Spilled > 0
+}
+
+// StreamCandles calls fn for every candle matching the query, ordered by
+// symbol and time
+func (cs *CandleService) StreamCandles(query models.HistoryQuery, fn func(*models.Candle) error) error {
+	return cs.store.Stream(query, fn)
 }
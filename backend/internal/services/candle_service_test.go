@@ -1,24 +1,55 @@
 package services
 
 import (
+	"errors"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"stock-market-websocket/internal/config"
 	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/store"
+	"stock-market-websocket/internal/store/storetest"
 )
 
+// newTestCandleService creates a candle service backed by an in-memory store
+func newTestCandleService(t *testing.T, intervals ...string) *CandleService {
+	t.Helper()
+	return newTestCandleServiceWithStore(t, store.NewMemory(), intervals...)
+}
+
+// newTestCandleServiceWithStore creates a candle service writing to
+// candleStore
+func newTestCandleServiceWithStore(t *testing.T, candleStore store.CandleStore, intervals ...string) *CandleService {
+	t.Helper()
+	return NewCandleService(candleStore, &config.Env{
+		EXCHANGE_TIMEZONE:        "America/New_York",
+		CANDLE_CLOSE_GRACE:       2 * time.Second,
+		CANDLE_INTERVALS:         intervals,
+		CANDLE_WRITE_BATCH:       100,
+		CANDLE_WRITE_INTERVAL:    time.Hour,
+		CANDLE_WRITE_MAX_BACKOFF: time.Hour,
+		CANDLE_WAL_FILE:          filepath.Join(t.TempDir(), "candles.wal"),
+	})
+}
+
 func TestCandleService_ProcessTradeData(t *testing.T) {
-	// This is a basic test to ensure the service can be instantiated
-	// In a real application, you'd want to use a test database
-	service := &CandleService{
-		db:          nil, // Would be a test database in real tests
-		tempCandles: make(map[string]*models.TempCandle),
-		broadcastCh: make(chan *models.BroadcastMessage, 100),
-	}
+	candleStore := store.NewMemory()
+	service := newTestCandleServiceWithStore(t, candleStore)
+	service.writer.Start()
+
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, service.location)
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 10, Volume: 1, Timestamp: bucket.UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 12, Volume: 2, Timestamp: bucket.Add(30 * time.Second).UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 11, Volume: 1, Timestamp: bucket.Add(time.Minute).UnixMilli()})
+	service.Stop()
 
-	// Test that the broadcast channel is available
-	if service.GetBroadcastChannel() == nil {
-		t.Fatal("Broadcast channel should be nil")
+	candle, err := candleStore.Latest("AAPL", models.Interval1m)
+	if err != nil {
+		t.Fatalf("Failed to read stored candle: %v", err)
+	}
+	if candle == nil || !candle.Timestamp.Equal(bucket) || candle.Open != 10 || candle.High != 12 || candle.Close != 12 || candle.Volume != 3 {
+		t.Errorf("Expected the closed candle to be stored, got %+v", candle)
 	}
 }
 
@@ -61,3 +92,492 @@ func TestTempCandle_ToCandle(t *testing.T) {
 		t.Errorf("Expected volume 1000, got %d", candle.Volume)
 	}
 }
+
+func TestCandleService_AlignsCandlesToMinuteBoundaries(t *testing.T) {
+	service := newTestCandleService(t)
+
+	bucket := time.Date(2024, 6, 3, 9, 30, 0, 0, service.location)
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 189.1, Volume: 10, Timestamp: bucket.Add(17 * time.Second).UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "MSFT", Price: 415.5, Volume: 5, Timestamp: bucket.Add(42 * time.Second).UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 189.3, Volume: 20, Timestamp: bucket.Add(59*time.Second + 999*time.Millisecond).UnixMilli()})
+
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		tempCandle := service.tempCandles[symbol]
+		if !tempCandle.OpenTime.Equal(bucket) {
+			t.Errorf("Expected %s candle to open at %s, got %s", symbol, bucket, tempCandle.OpenTime)
+		}
+		if !tempCandle.CloseTime.Equal(bucket.Add(time.Minute)) {
+			t.Errorf("Expected %s candle to close at %s, got %s", symbol, bucket.Add(time.Minute), tempCandle.CloseTime)
+		}
+	}
+
+	if volume := service.tempCandles["AAPL"].Volume; volume != 30 {
+		t.Errorf("Expected both AAPL trades in one candle with volume 30, got %d", volume)
+	}
+}
+
+func TestCandleService_ClosesCandlesWithoutNewTrades(t *testing.T) {
+	service := newTestCandleService(t)
+
+	bucket := time.Date(2024, 6, 3, 15, 59, 0, 0, service.location)
+	service.ProcessTradeData(&models.TradeData{Symbol: "IBM", Price: 170.2, Volume: 10, Timestamp: bucket.Add(30 * time.Second).UnixMilli()})
+	<-service.GetBroadcastChannel() // live update
+
+	// Trade clock is 30s into the bucket; the candle must stay open until the
+	// bucket ends plus the grace period
+	service.eventLag = 0
+	service.closeExpiredCandles(bucket.Add(time.Minute + time.Second))
+	if _, open := service.tempCandles["IBM"]; !open {
+		t.Fatal("Expected candle to stay open during the grace period")
+	}
+
+	service.closeExpiredCandles(bucket.Add(time.Minute + 2*time.Second))
+	if _, open := service.tempCandles["IBM"]; open {
+		t.Fatal("Expected candle to be closed after the grace period")
+	}
+
+	msg := <-service.GetBroadcastChannel()
+	if msg.UpdateType != models.Closed || !msg.Candle.Timestamp.Equal(bucket) {
+		t.Errorf("Expected closed candle for %s, got %s at %s", bucket, msg.UpdateType, msg.Candle.Timestamp)
+	}
+
+	// A late trade for the closed bucket must not reopen it
+	service.ProcessTradeData(&models.TradeData{Symbol: "IBM", Price: 171.0, Volume: 5, Timestamp: bucket.Add(59 * time.Second).UnixMilli()})
+	if _, open := service.tempCandles["IBM"]; open {
+		t.Error("Expected late trade to be dropped")
+	}
+}
+
+func TestCandleService_RollsUpHigherTimeframes(t *testing.T) {
+	service := newTestCandleService(t, "5m")
+
+	bucket := time.Date(2024, 6, 3, 9, 30, 0, 0, service.location)
+	prices := []float64{100, 104, 98, 101, 102, 103}
+	for minute, price := range prices {
+		service.ProcessTradeData(&models.TradeData{
+			Symbol:    "NVDA",
+			Price:     price,
+			Volume:    10,
+			Timestamp: bucket.Add(time.Duration(minute) * time.Minute).UnixMilli(),
+		})
+	}
+
+	// The 5m bucket closes once its grace period has passed on the trade clock
+	service.eventLag = 0
+	service.closeExpiredCandles(bucket.Add(5*time.Minute + 2*time.Second))
+
+	var closed5m []*models.Candle
+	var live5m *models.Candle
+	for len(service.GetBroadcastChannel()) > 0 {
+		msg := <-service.GetBroadcastChannel()
+		if msg.Candle.Interval != models.Interval5m {
+			continue
+		}
+		if msg.UpdateType == models.Closed {
+			closed5m = append(closed5m, msg.Candle)
+		} else {
+			live5m = msg.Candle
+		}
+	}
+
+	if len(closed5m) != 1 {
+		t.Fatalf("Expected one closed 5m candle, got %d", len(closed5m))
+	}
+	candle := closed5m[0]
+	if !candle.Timestamp.Equal(bucket) {
+		t.Errorf("Expected 5m candle at %s, got %s", bucket, candle.Timestamp)
+	}
+	if candle.Open != 100 || candle.High != 104 || candle.Low != 98 || candle.Close != 102 || candle.Volume != 50 {
+		t.Errorf("Unexpected 5m OHLCV: %+v", candle)
+	}
+
+	if live5m == nil || !live5m.Timestamp.Equal(bucket.Add(5*time.Minute)) || live5m.Close != 103 {
+		t.Errorf("Expected live 5m candle for the next bucket, got %+v", live5m)
+	}
+}
+
+func TestInterval_BucketStart(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+
+	tradeTime := time.Date(2024, 6, 3, 15, 47, 31, 0, location)
+	expected := map[models.Interval]time.Time{
+		models.Interval1m:  time.Date(2024, 6, 3, 15, 47, 0, 0, location),
+		models.Interval5m:  time.Date(2024, 6, 3, 15, 45, 0, 0, location),
+		models.Interval15m: time.Date(2024, 6, 3, 15, 45, 0, 0, location),
+		models.Interval1h:  time.Date(2024, 6, 3, 15, 0, 0, 0, location),
+		models.Interval4h:  time.Date(2024, 6, 3, 12, 0, 0, 0, location),
+		models.Interval1d:  time.Date(2024, 6, 3, 0, 0, 0, 0, location),
+	}
+
+	for interval, want := range expected {
+		if got := interval.BucketStart(tradeTime, location); !got.Equal(want) {
+			t.Errorf("Expected %s bucket to start at %s, got %s", interval, want, got)
+		}
+	}
+}
+
+func TestInterval_BucketEndAcrossFallBack(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+
+	// Clocks go back from 2:00 EDT to 1:00 EST on 3 November 2024
+	day := time.Date(2024, 11, 3, 0, 0, 0, 0, location)
+	for _, interval := range []models.Interval{models.Interval1h, models.Interval4h, models.Interval1d} {
+		for minute := range 25 * 60 {
+			tradeTime := day.Add(time.Duration(minute) * time.Minute)
+			start := interval.BucketStart(tradeTime, location)
+			end := interval.BucketEnd(start)
+			if tradeTime.Before(start) || !tradeTime.Before(end) {
+				t.Fatalf("Expected %s bucket [%s, %s) to contain %s", interval, start, end, tradeTime)
+			}
+			if next := interval.BucketStart(end, location); !next.Equal(end) {
+				t.Fatalf("Expected the %s bucket after %s to start at its end %s, got %s", interval, start, end, next)
+			}
+		}
+	}
+
+	start := models.Interval4h.BucketStart(time.Date(2024, 11, 3, 1, 30, 0, 0, location), location)
+	if end := models.Interval4h.BucketEnd(start); end.Sub(start) != 5*time.Hour {
+		t.Errorf("Expected the 4h bucket spanning the fall back to last 5 hours, got %s", end.Sub(start))
+	}
+}
+
+func TestCandleService_GetCandlesRejectsInvalidCursor(t *testing.T) {
+	service := newTestCandleService(t)
+
+	_, _, err := service.GetCandles(models.CandleQuery{
+		Symbol:   "AAPL",
+		Interval: models.Interval1m,
+		Limit:    10,
+		Cursor:   "not-a-cursor",
+	})
+	if !errors.Is(err, store.ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestCandleService_SnapshotFromMemory(t *testing.T) {
+	service := newTestCandleService(t)
+
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, service.location)
+	// Each trade comes after the grace period of the previous minute
+	for i, price := range []float64{10, 11, 12} {
+		service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: price, Volume: 1, Timestamp: bucket.Add(time.Duration(i)*time.Minute + 5*time.Second).UnixMilli()})
+	}
+
+	// Two live updates, then a closed and a live update per new bucket
+	if len(service.GetBroadcastChannel()) != 5 {
+		t.Fatalf("Expected 5 updates, got %d", len(service.GetBroadcastChannel()))
+	}
+
+	snapshot, err := service.Snapshot("AAPL", models.Interval1m, 2)
+	if err != nil {
+		t.Fatalf("Failed to build snapshot: %v", err)
+	}
+	if snapshot.Seq != 5 {
+		t.Errorf("Expected seq 5, got %d", snapshot.Seq)
+	}
+	if len(snapshot.Candles) != 2 || snapshot.Candles[0].Close != 10 || snapshot.Candles[1].Close != 11 {
+		t.Errorf("Unexpected closed candles: %+v", snapshot.Candles)
+	}
+	if snapshot.Live == nil || snapshot.Live.Close != 12 {
+		t.Errorf("Unexpected live candle: %+v", snapshot.Live)
+	}
+}
+
+func TestStreamState_Since(t *testing.T) {
+	state := newStreamState(2)
+	key := models.Subscription{Symbol: "AAPL", Interval: models.Interval1m}
+	bucket := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+
+	for i := range 3 {
+		state.record(&models.BroadcastMessage{UpdateType: models.Closed, Seq: uint64(2*i + 1), Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket.Add(time.Duration(i) * time.Minute)}})
+		state.record(&models.BroadcastMessage{UpdateType: models.Live, Seq: uint64(2*i + 2), Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket.Add(time.Duration(i+1) * time.Minute)}})
+	}
+
+	updates, seq, ok := state.since(key, 4)
+	if !ok || seq != 6 || len(updates) != 2 || updates[0].Seq != 5 || updates[1].UpdateType != models.Live {
+		t.Errorf("Unexpected replay after seq 4: %+v (seq %d, ok %t)", updates, seq, ok)
+	}
+
+	// The closed candle with seq 1 was trimmed from memory
+	if _, _, ok := state.since(key, 0); ok {
+		t.Error("Expected replay from a trimmed seq to fail")
+	}
+
+	// A seq from before a restart cannot be replayed
+	if _, _, ok := state.since(key, 99); ok {
+		t.Error("Expected replay from an unknown seq to fail")
+	}
+}
+
+func TestCandleService_ApplyRemoteMirrorsIngestNode(t *testing.T) {
+	ingest := newTestCandleService(t)
+	edge := newTestCandleService(t)
+
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, ingest.location)
+	ingest.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 10, Volume: 1, Timestamp: bucket.UnixMilli()})
+	ingest.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 11, Volume: 1, Timestamp: bucket.Add(time.Minute).UnixMilli()})
+
+	for len(ingest.GetBroadcastChannel()) > 0 {
+		msg := <-ingest.GetBroadcastChannel()
+		edge.ApplyRemote(msg)
+		if forwarded := <-edge.GetBroadcastChannel(); forwarded != msg {
+			t.Fatalf("Expected update to be broadcast by the edge node")
+		}
+	}
+
+	want, _ := ingest.Snapshot("AAPL", models.Interval1m, 10)
+	got, _ := edge.Snapshot("AAPL", models.Interval1m, 10)
+	if got.Seq != want.Seq || len(got.Candles) != len(want.Candles) || got.Live == nil || got.Live.Close != 11 {
+		t.Errorf("Expected edge snapshot %+v, got %+v", want, got)
+	}
+}
+
+func TestCandleService_SeedsRollupFromStore(t *testing.T) {
+	candleStore := store.NewMemory()
+	bucket := time.Date(2024, 6, 3, 9, 30, 0, 0, time.UTC)
+
+	// Minutes stored before a restart
+	candleStore.Upsert([]*models.Candle{
+		{Symbol: "NVDA", Interval: models.Interval1m, Open: 100, High: 110, Low: 90, Close: 105, Volume: 10, Timestamp: bucket},
+		{Symbol: "NVDA", Interval: models.Interval1m, Open: 105, High: 106, Low: 104, Close: 104, Volume: 10, Timestamp: bucket.Add(time.Minute)},
+	})
+
+	service := newTestCandleServiceWithStore(t, candleStore, "5m")
+	service.ProcessTradeData(&models.TradeData{Symbol: "NVDA", Price: 103, Volume: 5, Timestamp: bucket.Add(2 * time.Minute).UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "NVDA", Price: 101, Volume: 5, Timestamp: bucket.Add(3*time.Minute + 5*time.Second).UnixMilli()})
+	service.seeds.Wait()
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	rollup := service.rollups[models.Interval5m]["NVDA"]
+	if rollup == nil || rollup.OpenPrice != 100 || rollup.HighPrice != 110 || rollup.LowPrice != 90 || rollup.ClosePrice != 103 || rollup.Volume != 25 {
+		t.Errorf("Expected the 5m rollup to include the stored minutes, got %+v", rollup)
+	}
+}
+
+func TestCandleService_ReplaySinceIncludesUnwrittenCandles(t *testing.T) {
+	service := newTestCandleService(t)
+
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, service.location)
+	for i := range 4 {
+		service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: float64(10 + i), Volume: 1, Timestamp: bucket.Add(time.Duration(i)*time.Minute + 5*time.Second).UnixMilli()})
+	}
+
+	// The writer is not started, so the three closed candles are only queued
+	updates, seq, ok, err := service.ReplaySince("AAPL", models.Interval1m, bucket.Add(time.Minute), 100)
+	if err != nil || !ok {
+		t.Fatalf("Expected a replay, got ok %t, err %v", ok, err)
+	}
+	if seq != 7 || len(updates) != 3 {
+		t.Fatalf("Expected two closed candles and the live one at seq 7, got %d updates at seq %d", len(updates), seq)
+	}
+	if updates[0].Candle.Close != 11 || updates[1].Candle.Close != 12 || updates[2].UpdateType != models.Live {
+		t.Errorf("Unexpected replay %+v", updates)
+	}
+}
+
+func TestCandleService_ApplyRemoteResyncsAfterRestartOrGap(t *testing.T) {
+	edge := newTestCandleService(t)
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, edge.location)
+	update := func(epoch int64, seq uint64, minute int) *models.BroadcastMessage {
+		edge.ApplyRemote(&models.BroadcastMessage{UpdateType: models.Closed, Epoch: epoch, Seq: seq, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket.Add(time.Duration(minute) * time.Minute)}})
+		return <-edge.GetBroadcastChannel()
+	}
+
+	for seq := range uint64(3) {
+		if msg := update(1, seq+1, int(seq)); msg.Resync {
+			t.Fatalf("Expected contiguous update %d not to resync", seq+1)
+		}
+	}
+
+	// Updates 4 and 5 were lost on the backplane
+	if msg := update(1, 6, 5); !msg.Resync {
+		t.Error("Expected an update after a gap to resync")
+	}
+	if _, _, ok := edge.Replay("AAPL", models.Interval1m, 1, 2); ok {
+		t.Error("Expected a replay across the gap to fail")
+	}
+	if updates, _, ok := edge.Replay("AAPL", models.Interval1m, 1, 5); !ok || len(updates) != 1 {
+		t.Errorf("Expected a replay after the gap to succeed, got %+v (ok %t)", updates, ok)
+	}
+
+	// The ingest node restarted and numbers from 1 again
+	if msg := update(2, 1, 6); !msg.Resync {
+		t.Error("Expected the first update of a new epoch to resync")
+	}
+	if snapshot, _ := edge.Snapshot("AAPL", models.Interval1m, 10); snapshot.Seq != 1 {
+		t.Errorf("Expected the snapshot seq to restart, got %d", snapshot.Seq)
+	}
+	if msg := update(2, 2, 7); msg.Resync {
+		t.Error("Expected the next update of the new epoch not to resync")
+	}
+}
+
+func TestCandleService_KeepsPreviousMinuteOpenDuringGrace(t *testing.T) {
+	service := newTestCandleService(t)
+
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, service.location)
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 10, Volume: 1, Timestamp: bucket.Add(59 * time.Second).UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 11, Volume: 1, Timestamp: bucket.Add(time.Minute).UnixMilli()})
+
+	// Arrives after the next minute started but within the grace period
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 9, Volume: 2, Timestamp: bucket.Add(59*time.Second + 500*time.Millisecond).UnixMilli()})
+	if previous := service.previous["AAPL"]; previous == nil || previous.Volume != 3 || previous.LowPrice != 9 {
+		t.Fatalf("Expected the late trade in the previous minute, got %+v", previous)
+	}
+
+	// A third minute closes the oldest one
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 12, Volume: 1, Timestamp: bucket.Add(2 * time.Minute).UnixMilli()})
+	if previous := service.previous["AAPL"]; previous == nil || !previous.OpenTime.Equal(bucket.Add(time.Minute)) {
+		t.Fatalf("Expected at most two open minutes, got previous %+v", previous)
+	}
+
+	var closed []*models.Candle
+	for len(service.GetBroadcastChannel()) > 0 {
+		if msg := <-service.GetBroadcastChannel(); msg.UpdateType == models.Closed {
+			closed = append(closed, msg.Candle)
+		}
+	}
+	if len(closed) != 1 || !closed[0].Timestamp.Equal(bucket) || closed[0].Volume != 3 || closed[0].Close != 9 {
+		t.Errorf("Expected the first minute to close with the late trade, got %+v", closed)
+	}
+}
+
+func TestCandleService_WritesOnlyMinutesWithAggregates(t *testing.T) {
+	candleStore := storetest.NewAggregateMemory()
+	service := newTestCandleServiceWithStore(t, candleStore, "5m")
+	service.writer.Start()
+
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, service.location)
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 10, Volume: 1, Timestamp: bucket.UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 11, Volume: 1, Timestamp: bucket.Add(5*time.Minute + 5*time.Second).UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 12, Volume: 1, Timestamp: bucket.Add(6*time.Minute + 5*time.Second).UnixMilli()})
+
+	closed := 0
+	for len(service.GetBroadcastChannel()) > 0 {
+		if msg := <-service.GetBroadcastChannel(); msg.UpdateType == models.Closed && msg.Candle.Interval == models.Interval5m {
+			closed++
+		}
+	}
+	if closed != 1 {
+		t.Errorf("Expected the 5m candle to still be broadcast as closed, got %d", closed)
+	}
+	service.Stop()
+
+	if minute, _ := candleStore.Latest("AAPL", models.Interval1m); minute == nil {
+		t.Error("Expected the 1m candles to be written")
+	}
+	if rollup, _ := candleStore.Latest("AAPL", models.Interval5m); rollup != nil {
+		t.Errorf("Expected the 5m candle to be left to the aggregates, got %+v", rollup)
+	}
+}
+
+func TestCandleService_PromoteServesOwnEpoch(t *testing.T) {
+	follower := newTestCandleService(t)
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, follower.location)
+
+	// Updates of the previous leader applied from the backplane
+	for seq := range uint64(3) {
+		follower.ApplyRemote(&models.BroadcastMessage{UpdateType: models.Closed, Epoch: 1, Seq: seq + 1, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket.Add(time.Duration(seq) * time.Minute)}})
+		<-follower.GetBroadcastChannel()
+	}
+
+	follower.Promote()
+	follower.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 10, Volume: 1, Timestamp: bucket.Add(5 * time.Minute).UnixMilli()})
+	msg := <-follower.GetBroadcastChannel()
+	if msg.Epoch == 1 || msg.Seq != 1 || !msg.Resync {
+		t.Fatalf("Expected the first update after promotion to resync in a new epoch, got %+v", msg)
+	}
+
+	snapshot, _ := follower.Snapshot("AAPL", models.Interval1m, 10)
+	if snapshot.Epoch != msg.Epoch || snapshot.Seq != 1 {
+		t.Errorf("Expected the snapshot in epoch %d at seq 1, got %+v", msg.Epoch, snapshot)
+	}
+	if _, _, ok := follower.Replay("AAPL", models.Interval1m, 1, 3); ok {
+		t.Error("Expected a resume with the previous leader's epoch to fail")
+	}
+	if _, _, ok := follower.Replay("AAPL", models.Interval1m, msg.Epoch, 0); !ok {
+		t.Error("Expected a resume in the new epoch to succeed")
+	}
+
+	follower.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 11, Volume: 1, Timestamp: bucket.Add(5*time.Minute + time.Second).UnixMilli()})
+	if next := <-follower.GetBroadcastChannel(); next.Resync || next.Epoch != msg.Epoch {
+		t.Errorf("Expected the next update not to resync, got %+v", next)
+	}
+}
+
+func TestCandleService_ReplayRejectsSeqFromPreviousRun(t *testing.T) {
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	trade := func(service *CandleService, minute int) {
+		service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: float64(10 + minute), Volume: 1, Timestamp: bucket.Add(time.Duration(minute)*time.Minute + 5*time.Second).UnixMilli()})
+	}
+
+	previous := newTestCandleService(t)
+	trade(previous, 0)
+	trade(previous, 1)
+	before, _ := previous.Snapshot("AAPL", models.Interval1m, 10)
+
+	// After a restart the seqs start again and soon pass the client's seq
+	restarted := newTestCandleService(t)
+	restarted.epoch = before.Epoch + 1
+	for minute := 5; minute < 10; minute++ {
+		trade(restarted, minute)
+	}
+	after, _ := restarted.Snapshot("AAPL", models.Interval1m, 10)
+	if after.Seq <= before.Seq || after.Epoch == before.Epoch {
+		t.Fatalf("Expected the restarted run to pass seq %d in a new epoch, got %+v", before.Seq, after)
+	}
+
+	if _, _, ok := restarted.Replay("AAPL", models.Interval1m, before.Epoch, before.Seq); ok {
+		t.Error("Expected a resume with a seq from the previous run to fail")
+	}
+	if updates, _, ok := restarted.Replay("AAPL", models.Interval1m, after.Epoch, after.Seq-2); !ok || len(updates) == 0 || updates[0].Epoch != after.Epoch {
+		t.Errorf("Expected a resume within the run to succeed, got %+v (ok %t)", updates, ok)
+	}
+}
+
+func TestCandleService_LateTradeDoesNotHoldBackTradeClock(t *testing.T) {
+	service := newTestCandleService(t)
+
+	now := time.Now()
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 10, Volume: 1, Timestamp: now.Add(-2 * time.Minute).UnixMilli()})
+	service.ProcessTradeData(&models.TradeData{Symbol: "MSFT", Price: 20, Volume: 1, Timestamp: now.UnixMilli()})
+
+	// A trade of another symbol delivered an hour late
+	service.ProcessTradeData(&models.TradeData{Symbol: "TSLA", Price: 30, Volume: 1, Timestamp: now.Add(-time.Hour).UnixMilli()})
+
+	service.closeExpiredCandles(time.Now())
+	if _, open := service.tempCandles["AAPL"]; open {
+		t.Error("Expected the late trade not to move the trade clock back")
+	}
+}
+
+func TestCandleService_StopClosesEndedCandlesWithinGrace(t *testing.T) {
+	candleStore := store.NewMemory()
+	service := newTestCandleServiceWithStore(t, candleStore, "5m")
+	service.writer.Start()
+
+	bucket := time.Date(2024, 6, 3, 10, 0, 0, 0, service.location)
+	service.ProcessTradeData(&models.TradeData{Symbol: "AAPL", Price: 10, Volume: 1, Timestamp: bucket.Add(4*time.Minute + 30*time.Second).UnixMilli()})
+
+	// The trade clock has moved one second past the minute and its 5m
+	// bucket, so both are still within the grace period
+	service.mutex.Lock()
+	service.eventLag = time.Since(bucket.Add(5*time.Minute + time.Second))
+	service.mutex.Unlock()
+	service.Stop()
+
+	if minute, _ := candleStore.Latest("AAPL", models.Interval1m); minute == nil || !minute.Timestamp.Equal(bucket.Add(4*time.Minute)) {
+		t.Errorf("Expected the ended minute to be written on stop, got %+v", minute)
+	}
+	if rollup, _ := candleStore.Latest("AAPL", models.Interval5m); rollup == nil || !rollup.Timestamp.Equal(bucket) || rollup.Close != 10 {
+		t.Errorf("Expected the completed 5m candle to be written on stop, got %+v", rollup)
+	}
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// writeQueueSize is the number of closed candles buffered for writing
+const writeQueueSize = 10000
+
+// WriterStats reports the backlog of the candle writer
+type WriterStats struct {
+	Queued    int    `json:"queued"`
+	Spilled   int64  `json:"spilled"`
+	Written   int64  `json:"written"`
+	DBHealthy bool   `json:"db_healthy"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// candleWriter persists closed candles in batches on its own goroutine so
+// that a slow or unavailable database never blocks trade processing. While
+// the database is down, batches are appended to a write-ahead file and
+// written once it is back. The file is moved aside before it is replayed, so
+// spills go to a fresh file rather than wait for the database.
+type candleWriter struct {
+	write      func(batch []*models.Candle) error
+	queue      chan *models.Candle
+	batchSize  int
+	interval   time.Duration
+	maxBackoff time.Duration
+	walPath    string
+	started    atomic.Bool
+	done       chan struct{}
+
+	// Enqueue spills candles to the write-ahead file once closed is set
+	closeMutex sync.Mutex
+	closed     bool
+
+	// Guards appending to and moving the write-ahead file, which Enqueue
+	// also appends to when the queue is full. spilled counts the candles in
+	// it and in the file being replayed.
+	walMutex sync.Mutex
+	spilled  atomic.Int64
+
+	written   atomic.Int64
+	healthy   atomic.Bool
+	lastError atomic.Value // string
+}
+
+// newCandleWriter creates a writer calling write with batches of up to
+// batchSize candles
+func newCandleWriter(write func(batch []*models.Candle) error, batchSize int, interval, maxBackoff time.Duration, walPath string) *candleWriter {
+	w := &candleWriter{
+		write:      write,
+		queue:      make(chan *models.Candle, writeQueueSize),
+		batchSize:  batchSize,
+		interval:   interval,
+		maxBackoff: maxBackoff,
+		walPath:    walPath,
+		done:       make(chan struct{}),
+	}
+	w.healthy.Store(true)
+	return w
+}
+
+// Start begins writing queued candles, starting with any left in the
+// write-ahead files by a previous run
+func (w *candleWriter) Start() {
+	if w.started.CompareAndSwap(false, true) {
+		w.spilled.Store(repairWAL(w.walPath) + repairWAL(w.replayPath()))
+		go w.run()
+	}
+}
+
+// Enqueue queues a candle for writing without waiting for the database. If
+// the queue is full or the writer is closed the candle goes straight to the
+// write-ahead file, which waits for a replay of the file in progress.
+func (w *candleWriter) Enqueue(candle *models.Candle) {
+	w.closeMutex.Lock()
+	defer w.closeMutex.Unlock()
+
+	if w.closed {
+		if err := w.spill([]*models.Candle{candle}); err != nil {
+			log.Printf("Failed to spill %s candle for %s: %v", candle.Interval, candle.Symbol, err)
+		}
+		return
+	}
+
+	select {
+	case w.queue <- candle:
+	default:
+		if err := w.spill([]*models.Candle{candle}); err != nil {
+			log.Printf("Failed to spill %s candle for %s: %v", candle.Interval, candle.Symbol, err)
+		}
+	}
+}
+
+// Close writes or spills every queued candle and stops the writer. Candles
+// enqueued afterwards are spilled for the next run.
+func (w *candleWriter) Close() {
+	w.closeMutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.closeMutex.Unlock()
+
+	if !w.started.Load() {
+		// Nothing will write the queue, so keep it for the next run
+		var queued []*models.Candle
+		for candle := range w.queue {
+			queued = append(queued, candle)
+		}
+		if len(queued) == 0 {
+			return
+		}
+		if err := w.spill(queued); err != nil {
+			log.Printf("Failed to spill %d candles: %v", len(queued), err)
+		}
+		return
+	}
+	<-w.done
+}
+
+// Stats returns the writer's backlog
+func (w *candleWriter) Stats() WriterStats {
+	stats := WriterStats{
+		Queued:    len(w.queue),
+		Spilled:   w.spilled.Load(),
+		Written:   w.written.Load(),
+		DBHealthy: w.healthy.Load(),
+	}
+	if lastError, ok := w.lastError.Load().(string); ok {
+		stats.LastError = lastError
+	}
+	return stats
+}
+
+// run collects candles into batches and writes them every interval or when
+// a batch is full. After a failure, batches are spilled until a retry of the
+// write-ahead file succeeds; retries back off exponentially.
+func (w *candleWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	backoff := w.interval
+	nextRetry := time.Now()
+
+	batch := make([]*models.Candle, 0, w.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if w.spilled.Load() > 0 {
+			// Keep the write order: older candles are still in the file
+			if err := w.spill(batch); err != nil {
+				log.Printf("Failed to spill %d candles: %v", len(batch), err)
+			}
+		} else if err := w.writeBatch(batch); err != nil {
+			log.Printf("Failed to write %d candles, spilling to %s: %v", len(batch), w.walPath, err)
+			if err := w.spill(batch); err != nil {
+				log.Printf("Failed to spill %d candles: %v", len(batch), err)
+			}
+			nextRetry = time.Now().Add(backoff)
+		}
+		batch = make([]*models.Candle, 0, w.batchSize)
+	}
+
+	for {
+		select {
+		case candle, ok := <-w.queue:
+			if !ok {
+				flush()
+				if w.spilled.Load() > 0 {
+					w.replayWAL()
+				}
+				return
+			}
+			batch = append(batch, candle)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case now := <-ticker.C:
+			flush()
+			if w.spilled.Load() > 0 && !now.Before(nextRetry) {
+				if w.replayWAL() {
+					backoff = w.interval
+				} else {
+					backoff = min(2*backoff, w.maxBackoff)
+					nextRetry = now.Add(backoff)
+				}
+			}
+		}
+	}
+}
+
+// writeBatch writes a batch, recording the outcome
+func (w *candleWriter) writeBatch(batch []*models.Candle) error {
+	deduped := dedupeBatch(batch)
+	if err := w.write(deduped); err != nil {
+		w.healthy.Store(false)
+		w.lastError.Store(err.Error())
+		return err
+	}
+	w.healthy.Store(true)
+	w.written.Add(int64(len(deduped)))
+	return nil
+}
+
+// spill appends candles to the write-ahead file
+func (w *candleWriter) spill(candles []*models.Candle) error {
+	w.walMutex.Lock()
+	defer w.walMutex.Unlock()
+
+	file, err := os.OpenFile(w.walPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buffered)
+	for _, candle := range candles {
+		if err := encoder.Encode(candle); err != nil {
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	w.spilled.Add(int64(len(candles)))
+	return nil
+}
+
+// replayWAL writes the spilled candles until none are left, including those
+// spilled while it runs. It reports whether every spilled candle was written.
+func (w *candleWriter) replayWAL() bool {
+	for w.spilled.Load() > 0 {
+		if !w.replayFile() {
+			return false
+		}
+	}
+	return true
+}
+
+// replayFile moves the write-ahead file aside, unless a previous replay left
+// one, and writes its candles in batches, removing it once all of them are
+// written. Only the move holds walMutex, so spills go on while the database
+// is slow. It reports whether the file was fully written.
+func (w *candleWriter) replayFile() bool {
+	replayPath := w.replayPath()
+
+	w.walMutex.Lock()
+	_, err := os.Stat(replayPath)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Rename(w.walPath, replayPath)
+	}
+	w.walMutex.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		w.spilled.Store(0)
+		return true
+	}
+	if err != nil {
+		log.Printf("Failed to move %s aside: %v", w.walPath, err)
+		return false
+	}
+
+	file, err := os.Open(replayPath)
+	if err != nil {
+		log.Printf("Failed to open %s: %v", replayPath, err)
+		return false
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var replayed int64
+	for {
+		batch, lines, err := w.readBatch(reader)
+		if err != nil {
+			log.Printf("Failed to read %s: %v", replayPath, err)
+			return false
+		}
+		if lines == 0 {
+			break
+		}
+		// Rewriting a batch after a partial replay is harmless because
+		// candles are upserted
+		if len(batch) > 0 {
+			if err := w.writeBatch(batch); err != nil {
+				log.Printf("Database still unavailable, %d candles remain in %s: %v", w.spilled.Load(), replayPath, err)
+				return false
+			}
+		}
+		replayed += int64(lines)
+	}
+
+	if err := os.Remove(replayPath); err != nil {
+		log.Printf("Failed to remove %s: %v", replayPath, err)
+		return false
+	}
+	log.Printf("Wrote %d spilled candles from %s", replayed, replayPath)
+	if w.spilled.Add(-replayed) < 0 {
+		w.spilled.Store(0)
+	}
+	return true
+}
+
+// replayPath returns the path the write-ahead file is replayed from
+func (w *candleWriter) replayPath() string {
+	return w.walPath + ".replay"
+}
+
+// readBatch decodes up to a batch of candles, one per line, and returns the
+// number of lines read. Lines that do not decode, such as one torn by a crash
+// mid-write, are skipped so that they cannot block the candles after them.
+func (w *candleWriter) readBatch(reader *bufio.Reader) ([]*models.Candle, int, error) {
+	batch := make([]*models.Candle, 0, w.batchSize)
+	lines := 0
+	for lines < w.batchSize {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines++
+			var candle models.Candle
+			if decodeErr := json.Unmarshal(line, &candle); decodeErr != nil {
+				log.Printf("Skipping invalid candle in %s: %v", w.replayPath(), decodeErr)
+			} else {
+				batch = append(batch, &candle)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return batch, lines, nil
+}
+
+// dedupeBatch keeps the last candle of every key, since a multi-row upsert
+// cannot update the same row twice
+func dedupeBatch(batch []*models.Candle) []*models.Candle {
+	latest := make(map[models.Subscription]map[int64]int, len(batch))
+	deduped := make([]*models.Candle, 0, len(batch))
+	for _, candle := range batch {
+		key := models.Subscription{Symbol: candle.Symbol, Interval: candle.Interval}
+		if latest[key] == nil {
+			latest[key] = make(map[int64]int)
+		}
+		bucket := candle.Timestamp.UnixNano()
+		if i, ok := latest[key][bucket]; ok {
+			deduped[i] = candle
+			continue
+		}
+		latest[key][bucket] = len(deduped)
+		deduped = append(deduped, candle)
+	}
+	return deduped
+}
+
+// repairWAL truncates a write-ahead file to its last complete line, so that
+// spills after a crash mid-write do not follow a torn line, and returns the
+// number of candles left in it
+func repairWAL(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		log.Printf("Discarding %d bytes of a torn candle at the end of %s", len(data)-complete, path)
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			log.Printf("Failed to truncate %s: %v", path, err)
+		}
+	}
+
+	var count int64
+	for _, line := range bytes.Split(data[:complete], []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			count++
+		}
+	}
+	return count
+}
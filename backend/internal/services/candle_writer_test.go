@@ -0,0 +1,244 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// fakeDatabase records written batches and fails while down
+type fakeDatabase struct {
+	mutex   sync.Mutex
+	down    bool
+	batches [][]*models.Candle
+}
+
+func (db *fakeDatabase) write(batch []*models.Candle) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.down {
+		return errors.New("connection refused")
+	}
+	db.batches = append(db.batches, batch)
+	return nil
+}
+
+func (db *fakeDatabase) setDown(down bool) {
+	db.mutex.Lock()
+	db.down = down
+	db.mutex.Unlock()
+}
+
+// written returns the closing prices of every written candle
+func (db *fakeDatabase) written() []float64 {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	var closes []float64
+	for _, batch := range db.batches {
+		for _, candle := range batch {
+			closes = append(closes, candle.Close)
+		}
+	}
+	return closes
+}
+
+func testCandle(minute int, close float64) *models.Candle {
+	return &models.Candle{
+		Symbol:    "AAPL",
+		Interval:  models.Interval1m,
+		Close:     close,
+		Timestamp: time.Date(2024, 6, 3, 14, minute, 0, 0, time.UTC),
+	}
+}
+
+func TestCandleWriter_BatchesAndDedupes(t *testing.T) {
+	db := &fakeDatabase{}
+	writer := newCandleWriter(db.write, 2, time.Hour, time.Hour, filepath.Join(t.TempDir(), "candles.wal"))
+	writer.Start()
+
+	writer.Enqueue(testCandle(0, 1))
+	writer.Enqueue(testCandle(0, 2))
+	writer.Enqueue(testCandle(1, 3))
+	writer.Close()
+
+	if len(db.batches) != 2 {
+		t.Fatalf("Expected a full batch and a final one, got %d batches", len(db.batches))
+	}
+	if got := db.written(); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("Expected the latest candle of each bucket to be written, got %v", got)
+	}
+	if stats := writer.Stats(); stats.Written != 2 || stats.Spilled != 0 || !stats.DBHealthy {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCandleWriter_SpillsWhileDatabaseIsDown(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "candles.wal")
+	db := &fakeDatabase{down: true}
+
+	writer := newCandleWriter(db.write, 1, 10*time.Millisecond, 20*time.Millisecond, walPath)
+	writer.Start()
+	writer.Enqueue(testCandle(0, 1))
+	writer.Enqueue(testCandle(1, 2))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for writer.Stats().Spilled < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected candles to be spilled, got %+v", writer.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := writer.Stats(); stats.DBHealthy || stats.LastError == "" {
+		t.Errorf("Expected the database to be reported down, got %+v", stats)
+	}
+
+	db.setDown(false)
+	for writer.Stats().Spilled > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected spilled candles to be written, got %+v", writer.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	writer.Close()
+
+	if got := db.written(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("Expected spilled candles in order, got %v", got)
+	}
+}
+
+func TestCandleWriter_ReplaysSpillFromPreviousRun(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "candles.wal")
+
+	down := &fakeDatabase{down: true}
+	previous := newCandleWriter(down.write, 10, time.Hour, time.Hour, walPath)
+	previous.Start()
+	previous.Enqueue(testCandle(0, 1))
+	previous.Close()
+
+	db := &fakeDatabase{}
+	writer := newCandleWriter(db.write, 10, time.Hour, time.Hour, walPath)
+	writer.Start()
+	if writer.Stats().Spilled != 1 {
+		t.Fatalf("Expected the spilled candle to be counted, got %+v", writer.Stats())
+	}
+	writer.Close()
+
+	if got := db.written(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("Expected the spilled candle to be written, got %v", got)
+	}
+}
+
+func TestCandleWriter_SpillsCandlesEnqueuedAfterClose(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "candles.wal")
+	db := &fakeDatabase{}
+
+	writer := newCandleWriter(db.write, 10, time.Hour, time.Hour, walPath)
+	writer.Start()
+	writer.Close()
+
+	// Trades still processed during shutdown must not panic
+	writer.Enqueue(testCandle(0, 1))
+	writer.Close()
+
+	next := newCandleWriter(db.write, 10, time.Hour, time.Hour, walPath)
+	next.Start()
+	defer next.Close()
+	if next.Stats().Spilled != 1 {
+		t.Fatalf("Expected the late candle to be spilled for the next run, got %+v", next.Stats())
+	}
+}
+
+func TestCandleWriter_LeavesWALAloneWhenNeverStarted(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "candles.wal")
+
+	// Edge and follower nodes never start the writer
+	writer := newCandleWriter((&fakeDatabase{}).write, 10, time.Hour, time.Hour, walPath)
+	writer.Close()
+	if _, err := os.Stat(walPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no write-ahead file, got %v", err)
+	}
+}
+
+// blockingDatabase blocks every write until released
+type blockingDatabase struct {
+	fakeDatabase
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (db *blockingDatabase) write(batch []*models.Candle) error {
+	db.writing <- struct{}{}
+	<-db.release
+	return db.fakeDatabase.write(batch)
+}
+
+func TestCandleWriter_SpillsWhileReplaying(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "candles.wal")
+
+	down := &fakeDatabase{down: true}
+	previous := newCandleWriter(down.write, 10, time.Hour, time.Hour, walPath)
+	previous.Start()
+	previous.Enqueue(testCandle(0, 1))
+	previous.Close()
+
+	db := &blockingDatabase{writing: make(chan struct{}, 10), release: make(chan struct{})}
+	writer := newCandleWriter(db.write, 10, 10*time.Millisecond, time.Hour, walPath)
+	writer.Start()
+	<-db.writing
+
+	// A slow replay must not hold up spills from a full queue
+	spilled := make(chan error)
+	go func() { spilled <- writer.spill([]*models.Candle{testCandle(1, 2)}) }()
+	select {
+	case err := <-spilled:
+		if err != nil {
+			t.Fatalf("Spill failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the spill not to wait for the replay")
+	}
+
+	close(db.release)
+	writer.Close()
+	if got := db.written(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("Expected both spilled candles in order, got %v", got)
+	}
+	if stats := writer.Stats(); stats.Spilled != 0 {
+		t.Errorf("Expected nothing left spilled, got %+v", stats)
+	}
+}
+
+func TestCandleWriter_RecoversFromTornWAL(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "candles.wal")
+	wal := `{"symbol":"AAPL","interval":"1m","close":1,"timestamp":"2024-06-03T14:00:00Z"}
+not a candle
+{"symbol":"AAPL","interval":"1m","close":2,"timestamp":"2024-06-03T14:01:00Z"}
+{"symbol":"AAPL","interval":"1m","clo`
+	if err := os.WriteFile(walPath, []byte(wal), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := &fakeDatabase{down: true}
+	writer := newCandleWriter(db.write, 10, time.Hour, time.Hour, walPath)
+	writer.Start()
+	if data, _ := os.ReadFile(walPath); len(data) == 0 || data[len(data)-1] != '\n' {
+		t.Fatalf("Expected the torn line to be truncated, got %q", data)
+	}
+
+	// Spills after the repair follow a complete line
+	writer.Enqueue(testCandle(2, 3))
+	db.setDown(false)
+	writer.Close()
+
+	if got := db.written(); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("Expected every complete candle to be written, got %v", got)
+	}
+	if _, err := os.Stat(walPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the write-ahead file to be removed, got %v", err)
+	}
+}
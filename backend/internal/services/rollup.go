@@ -0,0 +1,131 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// rollUp folds a closed one-minute candle into every higher timeframe,
+// closing higher candles whose bucket it has moved past. The caller must
+// hold cs.mutex.
+func (cs *CandleService) rollUp(candle *models.Candle) {
+	for _, interval := range cs.intervals {
+		bucket := interval.BucketStart(candle.Timestamp, cs.location)
+		rollups := cs.rollups[interval]
+
+		// A nil entry means the previous bucket was closed; a missing entry
+		// means nothing was built for this symbol since startup
+		tempCandle, started := rollups[candle.Symbol]
+		if tempCandle != nil && bucket.After(tempCandle.OpenTime) {
+			cs.closeRollup(tempCandle)
+			tempCandle = nil
+		}
+		if tempCandle != nil && bucket.Before(tempCandle.OpenTime) {
+			log.Printf("Skipping out of order %s candle for %s rollup", candle.Symbol, interval)
+			continue
+		}
+
+		if tempCandle == nil {
+			tempCandle = models.NewRollup(candle.Symbol, interval, bucket)
+			if !started {
+				cs.seedRollup(tempCandle, candle.Timestamp)
+			}
+			rollups[candle.Symbol] = tempCandle
+		}
+		tempCandle.Merge(candle)
+	}
+}
+
+// seedRollup folds one-minute candles stored before this process started
+// into a newly started rollup so that a restart does not truncate it. The
+// candles are loaded in the background so that trade processing does not
+// wait for the database, and placed before the minutes merged meanwhile.
+func (cs *CandleService) seedRollup(tempCandle *models.TempCandle, before time.Time) {
+	cs.seeds.Add(1)
+	go func() {
+		defer cs.seeds.Done()
+
+		candles, _, err := cs.store.Range(models.CandleQuery{
+			Symbol:   tempCandle.Symbol,
+			Interval: models.Interval1m,
+			From:     tempCandle.OpenTime,
+			To:       before,
+		})
+		if err != nil {
+			log.Printf("Failed to load candles for %s %s rollup: %v", tempCandle.Symbol, tempCandle.Interval, err)
+			return
+		}
+		if len(candles) == 0 {
+			return
+		}
+
+		seeded := models.NewRollup(tempCandle.Symbol, tempCandle.Interval, tempCandle.OpenTime)
+		for i := range candles {
+			seeded.Merge(&candles[i])
+		}
+
+		cs.mutex.Lock()
+		defer cs.mutex.Unlock()
+		if cs.rollups[tempCandle.Interval][tempCandle.Symbol] != tempCandle {
+			log.Printf("%s %s rollup closed before its stored candles were loaded", tempCandle.Symbol, tempCandle.Interval)
+			return
+		}
+		seeded.Merge(tempCandle.ToCandle())
+		*tempCandle = *seeded
+	}()
+}
+
+// closeRollup persists and broadcasts a finished higher-timeframe candle.
+// The caller must hold cs.mutex.
+func (cs *CandleService) closeRollup(tempCandle *models.TempCandle) {
+	cs.rollups[tempCandle.Interval][tempCandle.Symbol] = nil
+	cs.persistCandle(tempCandle)
+}
+
+// closeExpiredRollups closes higher-timeframe candles whose bucket ended more
+// than the grace period ago and whose last minute has been folded in. The
+// caller must hold cs.mutex.
+func (cs *CandleService) closeExpiredRollups(eventTime time.Time) {
+	for _, interval := range cs.intervals {
+		for symbol, tempCandle := range cs.rollups[interval] {
+			if tempCandle == nil || eventTime.Before(tempCandle.CloseTime.Add(cs.closeGrace)) {
+				continue
+			}
+			if live := cs.tempCandles[symbol]; live != nil && live.OpenTime.Before(tempCandle.CloseTime) {
+				continue
+			}
+			if previous := cs.previous[symbol]; previous != nil && previous.OpenTime.Before(tempCandle.CloseTime) {
+				continue
+			}
+			cs.closeRollup(tempCandle)
+		}
+	}
+}
+
+// broadcastLiveRollups sends the in-progress candle of every higher
+// timeframe of a symbol, combining its closed minutes with the open ones.
+// The caller must hold cs.mutex.
+func (cs *CandleService) broadcastLiveRollups(symbol string) {
+	live := cs.tempCandles[symbol]
+	if live == nil {
+		return
+	}
+	previous := cs.previous[symbol]
+
+	for _, interval := range cs.intervals {
+		bucket := interval.BucketStart(live.OpenTime, cs.location)
+
+		view := models.NewRollup(symbol, interval, bucket)
+		if tempCandle := cs.rollups[interval][symbol]; tempCandle != nil && tempCandle.OpenTime.Equal(bucket) {
+			*view = *tempCandle
+		}
+		if previous != nil && interval.BucketStart(previous.OpenTime, cs.location).Equal(bucket) {
+			view.Merge(previous.ToCandle())
+		}
+		view.Merge(live.ToCandle())
+
+		cs.emit(models.Live, view.ToCandle())
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// recentCandleCapacity is the number of closed candles kept in memory per
+// symbol and interval for snapshots and resumes
+const recentCandleCapacity = 500
+
+// streamState tracks the sequence number of every symbol together with the
+// latest live candle and the most recently closed candles of every symbol
+// and interval
+type streamState struct {
+	capacity int
+	seqs     map[string]uint64
+	live     map[models.Subscription]*models.BroadcastMessage
+	closed   map[models.Subscription][]models.BroadcastMessage
+	trimmed  map[models.Subscription]bool
+
+	// Seqs of a symbol below its floor were missed and cannot be replayed
+	floors map[string]uint64
+}
+
+// newStreamState creates a stream state keeping capacity closed candles per key
+func newStreamState(capacity int) *streamState {
+	return &streamState{
+		capacity: capacity,
+		seqs:     make(map[string]uint64),
+		live:     make(map[models.Subscription]*models.BroadcastMessage),
+		closed:   make(map[models.Subscription][]models.BroadcastMessage),
+		trimmed:  make(map[models.Subscription]bool),
+		floors:   make(map[string]uint64),
+	}
+}
+
+// nextSeq returns the sequence number for the next update of symbol
+func (s *streamState) nextSeq(symbol string) uint64 {
+	return s.seqs[symbol] + 1
+}
+
+// record applies a broadcast update
+func (s *streamState) record(msg *models.BroadcastMessage) {
+	candle := *msg.Candle
+	key := models.Subscription{Symbol: candle.Symbol, Interval: candle.Interval}
+
+	if msg.Seq > s.seqs[candle.Symbol] {
+		s.seqs[candle.Symbol] = msg.Seq
+	}
+
+	if msg.UpdateType == models.Live {
+		s.live[key] = &models.BroadcastMessage{UpdateType: msg.UpdateType, Candle: &candle, Seq: msg.Seq}
+		return
+	}
+
+	if live := s.live[key]; live != nil && !live.Candle.Timestamp.After(candle.Timestamp) {
+		delete(s.live, key)
+	}
+
+	closed := append(s.closed[key], models.BroadcastMessage{UpdateType: msg.UpdateType, Candle: &candle, Seq: msg.Seq})
+	if len(closed) > s.capacity {
+		closed = append([]models.BroadcastMessage(nil), closed[len(closed)-s.capacity:]...)
+		s.trimmed[key] = true
+	}
+	s.closed[key] = closed
+}
+
+// skipTo forgets the updates of symbol after a gap in its sequence numbers,
+// so that only a seq from right before the next update can be replayed
+func (s *streamState) skipTo(symbol string, seq uint64) {
+	for key := range s.closed {
+		if key.Symbol == symbol {
+			delete(s.closed, key)
+			delete(s.trimmed, key)
+		}
+	}
+	for key := range s.live {
+		if key.Symbol == symbol {
+			delete(s.live, key)
+		}
+	}
+	s.seqs[symbol] = seq - 1
+	s.floors[symbol] = seq - 1
+}
+
+// snapshot returns the current sequence number of the symbol, up to limit
+// recently closed candles (oldest first) and the live candle of a key
+func (s *streamState) snapshot(key models.Subscription, limit int) (uint64, []models.Candle, *models.Candle) {
+	closed := s.closed[key]
+	if len(closed) > limit {
+		closed = closed[len(closed)-limit:]
+	}
+
+	candles := make([]models.Candle, 0, len(closed))
+	for _, msg := range closed {
+		candles = append(candles, *msg.Candle)
+	}
+	return s.seqs[key.Symbol], candles, s.liveCandle(key)
+}
+
+// since returns the closed candles of a key recorded after seq, oldest first,
+// followed by the live candle. ok is false when some of them are no longer
+// in memory or seq was not issued yet.
+func (s *streamState) since(key models.Subscription, seq uint64) (updates []models.BroadcastMessage, current uint64, ok bool) {
+	current = s.seqs[key.Symbol]
+	if seq > current || seq < s.floors[key.Symbol] {
+		return nil, current, false
+	}
+
+	closed := s.closed[key]
+	start := len(closed)
+	for start > 0 && closed[start-1].Seq > seq {
+		start--
+	}
+	if start == 0 && s.trimmed[key] && len(closed) > 0 && closed[0].Seq > seq {
+		return nil, current, false
+	}
+
+	for _, msg := range closed[start:] {
+		candle := *msg.Candle
+		updates = append(updates, models.BroadcastMessage{UpdateType: msg.UpdateType, Candle: &candle, Seq: msg.Seq})
+	}
+	if live := s.live[key]; live != nil && live.Seq > seq {
+		candle := *live.Candle
+		updates = append(updates, models.BroadcastMessage{UpdateType: live.UpdateType, Candle: &candle, Seq: live.Seq})
+	}
+	return updates, current, true
+}
+
+// closedSince returns copies of the closed candles of a key in memory that
+// start at or after since, oldest first, and whether older candles of the
+// key were trimmed from memory
+func (s *streamState) closedSince(key models.Subscription, since time.Time) ([]models.Candle, bool) {
+	var candles []models.Candle
+	for _, msg := range s.closed[key] {
+		if !msg.Candle.Timestamp.Before(since) {
+			candles = append(candles, *msg.Candle)
+		}
+	}
+	return candles, s.trimmed[key]
+}
+
+// liveCandle returns a copy of the live candle of a key, or nil
+func (s *streamState) liveCandle(key models.Subscription) *models.Candle {
+	live := s.live[key]
+	if live == nil {
+		return nil
+	}
+	candle := *live.Candle
+	return &candle
+}
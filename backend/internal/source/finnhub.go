@@ -0,0 +1,315 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// FinnhubClient manages the connection to Finnhub WebSocket
+type FinnhubClient struct {
+	conn            *websocket.Conn
+	connMutex       sync.Mutex
+	isConnected     bool
+	lastPingTime    time.Time
+	reconnectTicker *time.Ticker
+	config          *config.Env
+	symbols         []string
+	trades          chan models.TradeData
+	done            chan struct{}
+	stopOnce        sync.Once
+}
+
+// errFinnhubStopped is returned when Stop interrupts a connection attempt
+var errFinnhubStopped = errors.New("finnhub client stopped")
+
+// NewFinnhubClient creates a new Finnhub WebSocket client
+func NewFinnhubClient(cfg *config.Env, symbols []string) *FinnhubClient {
+	return &FinnhubClient{
+		config:          cfg,
+		symbols:         append([]string(nil), symbols...),
+		trades:          make(chan models.TradeData, 1000),
+		done:            make(chan struct{}),
+		reconnectTicker: time.NewTicker(30 * time.Second),
+		lastPingTime:    time.Now(),
+	}
+}
+
+// Name returns the source identifier
+func (f *FinnhubClient) Name() string {
+	return "finnhub"
+}
+
+// Connect establishes connection to Finnhub WebSocket with retry logic
+func (f *FinnhubClient) Connect() error {
+	f.connMutex.Lock()
+	defer f.connMutex.Unlock()
+
+	// Close existing connection if any
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+
+	// Reset connection status
+	f.isConnected = false
+
+	// Attempt to connect with retries
+	maxRetries := 5
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		if f.stopped() {
+			return errFinnhubStopped
+		}
+		log.Printf("Attempting to connect to Finnhub WebSocket (attempt %d/%d)", attempt, maxRetries)
+
+		ws, err := f.dial()
+		if err != nil {
+			log.Printf("Failed to connect to Finnhub WebSocket (attempt %d): %v", attempt, err)
+			if attempt < maxRetries {
+				if !f.wait(time.Duration(attempt) * 5 * time.Second) { // Exponential backoff
+					return errFinnhubStopped
+				}
+				continue
+			}
+			return fmt.Errorf("failed to connect to Finnhub WebSocket after %d attempts: %w", maxRetries, err)
+		}
+
+		// Connection successful
+		f.conn = ws
+		f.isConnected = true
+		f.lastPingTime = time.Now()
+		log.Printf("Successfully connected to Finnhub WebSocket")
+		return nil
+	}
+
+	return errors.New("failed to connect to Finnhub WebSocket")
+}
+
+// dial opens the socket and subscribes to the current symbols
+func (f *FinnhubClient) dial() (*websocket.Conn, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s?token=%s", f.config.FINNHUB_WS_URL, f.config.API_KEY), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Subscribe to symbols
+	for _, s := range f.symbols {
+		if err := writeSubscription(ws, "subscribe", s); err != nil {
+			ws.Close()
+			return nil, fmt.Errorf("failed to subscribe to symbol %s: %w", s, err)
+		}
+	}
+
+	// Set up ping handler
+	ws.SetPingHandler(func(appData string) error {
+		log.Printf("Received ping from Finnhub")
+		return ws.WriteMessage(websocket.PongMessage, []byte(appData))
+	})
+
+	// Set up pong handler
+	ws.SetPongHandler(func(appData string) error {
+		log.Printf("Received pong from Finnhub")
+		return nil
+	})
+
+	return ws, nil
+}
+
+// writeSubscription sends a Finnhub subscribe or unsubscribe frame
+func writeSubscription(ws *websocket.Conn, action, symbol string) error {
+	return ws.WriteJSON(map[string]interface{}{"type": action, "symbol": symbol})
+}
+
+// Start begins listening for messages and monitoring connection health
+func (f *FinnhubClient) Start() {
+	// Start message handling
+	go f.handleMessages()
+
+	// Start connection monitoring
+	go f.monitorConnection()
+}
+
+// Stop closes the connection and stops monitoring. The trades channel is
+// closed once the message handler has returned.
+func (f *FinnhubClient) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.done)
+	})
+	f.reconnectTicker.Stop()
+
+	f.connMutex.Lock()
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+	f.isConnected = false
+	f.connMutex.Unlock()
+}
+
+// Subscribe adds symbols and subscribes to them on the live connection
+func (f *FinnhubClient) Subscribe(symbols ...string) error {
+	f.connMutex.Lock()
+	defer f.connMutex.Unlock()
+
+	for _, s := range symbols {
+		if slices.Contains(f.symbols, s) {
+			continue
+		}
+		f.symbols = append(f.symbols, s)
+		if f.isConnected && f.conn != nil {
+			if err := writeSubscription(f.conn, "subscribe", s); err != nil {
+				return fmt.Errorf("failed to subscribe to symbol %s: %w", s, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Unsubscribe removes symbols and unsubscribes from them on the live connection
+func (f *FinnhubClient) Unsubscribe(symbols ...string) error {
+	f.connMutex.Lock()
+	defer f.connMutex.Unlock()
+
+	for _, s := range symbols {
+		if !slices.Contains(f.symbols, s) {
+			continue
+		}
+		f.symbols = slices.DeleteFunc(f.symbols, func(sym string) bool { return sym == s })
+		if f.isConnected && f.conn != nil {
+			if err := writeSubscription(f.conn, "unsubscribe", s); err != nil {
+				return fmt.Errorf("failed to unsubscribe from symbol %s: %w", s, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Trades returns the stream of trades received from Finnhub. The channel is
+// closed after Stop.
+func (f *FinnhubClient) Trades() <-chan models.TradeData {
+	return f.trades
+}
+
+// Status returns the current connection status
+func (f *FinnhubClient) Status() Status {
+	f.connMutex.Lock()
+	defer f.connMutex.Unlock()
+	return Status{
+		Source:       f.Name(),
+		Connected:    f.isConnected && f.conn != nil,
+		LastPingTime: f.lastPingTime,
+		Symbols:      append([]string(nil), f.symbols...),
+	}
+}
+
+// handleMessages processes incoming messages from Finnhub until Stop
+func (f *FinnhubClient) handleMessages() {
+	defer close(f.trades)
+
+	for !f.stopped() {
+		f.connMutex.Lock()
+		conn := f.conn
+		connected := f.isConnected
+		f.connMutex.Unlock()
+
+		if !connected || conn == nil {
+			log.Printf("No active Finnhub connection, waiting for reconnection...")
+			f.wait(5 * time.Second)
+			continue
+		}
+
+		finnhubMessage := &models.FinnhubMessage{}
+		if err := conn.ReadJSON(finnhubMessage); err != nil {
+			if f.stopped() {
+				return
+			}
+			log.Printf("Failed to read message from Finnhub WebSocket: %v", err)
+
+			// Mark connection as disconnected
+			f.connMutex.Lock()
+			f.isConnected = false
+			f.connMutex.Unlock()
+
+			// Wait before attempting reconnection
+			f.wait(5 * time.Second)
+			continue
+		}
+
+		if finnhubMessage.Type == "trade" {
+			for _, trade := range finnhubMessage.Data {
+				select {
+				case f.trades <- trade:
+				case <-f.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// monitorConnection checks connection health and reconnects if needed
+// until Stop
+func (f *FinnhubClient) monitorConnection() {
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-f.reconnectTicker.C:
+			f.connMutex.Lock()
+			connHealthy := f.isConnected && f.conn != nil
+			f.connMutex.Unlock()
+
+			if !connHealthy {
+				log.Printf("Connection unhealthy, attempting to reconnect...")
+				if err := f.Connect(); err != nil {
+					log.Printf("Reconnect failed: %v", err)
+				}
+			} else {
+				// Send ping to check if connection is still alive
+				f.connMutex.Lock()
+				if f.conn != nil {
+					if err := f.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+						log.Printf("Ping failed, connection may be dead: %v", err)
+						f.isConnected = false
+						f.connMutex.Unlock()
+						if err := f.Connect(); err != nil {
+							log.Printf("Reconnect failed: %v", err)
+						}
+						continue
+					}
+				}
+				f.connMutex.Unlock()
+			}
+		}
+	}
+}
+
+// stopped reports whether Stop was called
+func (f *FinnhubClient) stopped() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait sleeps for d and reports false if Stop was called meanwhile
+func (f *FinnhubClient) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-f.done:
+		return false
+	}
+}
@@ -0,0 +1,57 @@
+package source
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"stock-market-websocket/internal/config"
+)
+
+func TestFinnhubClient_StopClosesTrades(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"trade","data":[{"s":"AAPL","p":190.5,"t":1717421460000,"v":10}]}`))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Env{FINNHUB_WS_URL: "ws" + strings.TrimPrefix(server.URL, "http"), API_KEY: "test"}
+	client := NewFinnhubClient(cfg, []string{"AAPL"})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	client.Start()
+
+	select {
+	case trade := <-client.Trades():
+		if trade.Symbol != "AAPL" {
+			t.Errorf("Expected an AAPL trade, got %s", trade.Symbol)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a trade from Finnhub")
+	}
+
+	client.Stop()
+	select {
+	case _, ok := <-client.Trades():
+		if ok {
+			t.Error("Expected no trades after Stop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the trades channel to be closed after Stop")
+	}
+}
@@ -0,0 +1,258 @@
+package source
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// errReplayStopped is returned when Stop interrupts a replay
+var errReplayStopped = errors.New("replay stopped")
+
+// ReplaySource replays previously captured trades from an NDJSON file,
+// optionally gzip-compressed as written by the tick recorder
+type ReplaySource struct {
+	path         string
+	speed        float64
+	loop         bool
+	symbols      []string
+	mutex        sync.Mutex
+	isConnected  bool
+	lastPingTime time.Time
+	trades       chan models.TradeData
+	done         chan struct{}
+	stopOnce     sync.Once
+}
+
+// NewReplaySource creates a replay source for the given file. A speed of 1
+// replays in real time, 10 replays ten times faster and 0 replays as fast as
+// the consumer can keep up.
+func NewReplaySource(path string, speed float64, loop bool, symbols []string) *ReplaySource {
+	return &ReplaySource{
+		path:    path,
+		speed:   speed,
+		loop:    loop,
+		symbols: append([]string(nil), symbols...),
+		trades:  make(chan models.TradeData, 1000),
+		done:    make(chan struct{}),
+	}
+}
+
+// Name returns the source identifier
+func (r *ReplaySource) Name() string {
+	return "replay"
+}
+
+// Connect checks that the recording can be opened
+func (r *ReplaySource) Connect() error {
+	if r.path == "" {
+		return fmt.Errorf("REPLAY_FILE is required for the replay data source")
+	}
+
+	file, err := os.Open(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to open replay file: %w", err)
+	}
+	file.Close()
+
+	r.mutex.Lock()
+	r.isConnected = true
+	r.lastPingTime = time.Now()
+	r.mutex.Unlock()
+
+	log.Printf("Replaying trades from %s at speed %.2fx", r.path, r.speed)
+	return nil
+}
+
+// Start begins replaying trades
+func (r *ReplaySource) Start() {
+	go r.run()
+}
+
+// Stop halts the replay
+func (r *ReplaySource) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// Subscribe adds symbols to the replay filter
+func (r *ReplaySource) Subscribe(symbols ...string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, s := range symbols {
+		if !slices.Contains(r.symbols, s) {
+			r.symbols = append(r.symbols, s)
+		}
+	}
+	return nil
+}
+
+// Unsubscribe removes symbols from the replay filter
+func (r *ReplaySource) Unsubscribe(symbols ...string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.symbols = slices.DeleteFunc(r.symbols, func(s string) bool {
+		return slices.Contains(symbols, s)
+	})
+	return nil
+}
+
+// Trades returns the stream of replayed trades. The channel is closed when
+// the recording ends and looping is disabled.
+func (r *ReplaySource) Trades() <-chan models.TradeData {
+	return r.trades
+}
+
+// Status returns the current replay status
+func (r *ReplaySource) Status() Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return Status{
+		Source:       r.Name(),
+		Connected:    r.isConnected,
+		LastPingTime: r.lastPingTime,
+		Symbols:      append([]string(nil), r.symbols...),
+	}
+}
+
+// run replays the recording, once or in a loop
+func (r *ReplaySource) run() {
+	defer close(r.trades)
+	defer func() {
+		r.mutex.Lock()
+		r.isConnected = false
+		r.mutex.Unlock()
+	}()
+
+	// Shift timestamps on every pass so that looped replays keep moving forward
+	var offset int64
+	for {
+		first, last, err := r.replayFile(offset)
+		if errors.Is(err, errReplayStopped) {
+			return
+		}
+		if err != nil {
+			log.Printf("Replay failed: %v", err)
+			return
+		}
+		if !r.loop || last == 0 {
+			log.Printf("Replay of %s finished", r.path)
+			return
+		}
+		offset += last - first + 1000
+	}
+}
+
+// replayFile emits every trade in the file, shifted by offset milliseconds,
+// and returns the first and last original timestamps
+func (r *ReplaySource) replayFile(offset int64) (first, last int64, err error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(r.path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to open compressed replay file: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var trade models.TradeData
+		if err := json.Unmarshal(line, &trade); err != nil {
+			log.Printf("Skipping invalid trade on line %d of %s: %v", lineNumber, r.path, err)
+			continue
+		}
+
+		if first == 0 {
+			first = trade.Timestamp
+		}
+		if last != 0 && !r.wait(trade.Timestamp-last) {
+			return first, last, errReplayStopped
+		}
+		last = trade.Timestamp
+
+		if !r.isSubscribed(trade.Symbol) {
+			continue
+		}
+
+		trade.Timestamp += offset
+		select {
+		case r.trades <- trade:
+		case <-r.done:
+			return first, last, errReplayStopped
+		}
+
+		r.mutex.Lock()
+		r.lastPingTime = time.Now()
+		r.mutex.Unlock()
+	}
+
+	// Recordings that were not closed cleanly end without a gzip trailer
+	if err := scanner.Err(); errors.Is(err, io.ErrUnexpectedEOF) {
+		log.Printf("Replay file %s ends unexpectedly, treating as end of recording", r.path)
+	} else if err != nil {
+		return first, last, fmt.Errorf("failed to read replay file: %w", err)
+	}
+	return first, last, nil
+}
+
+// wait sleeps for the recorded gap between two trades scaled by the replay
+// speed. It returns false if the source was stopped while waiting.
+func (r *ReplaySource) wait(gapMillis int64) bool {
+	if r.speed <= 0 || gapMillis <= 0 {
+		select {
+		case <-r.done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	delay := time.Duration(float64(gapMillis) * float64(time.Millisecond) / r.speed)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// isSubscribed reports whether trades for symbol should be emitted
+func (r *ReplaySource) isSubscribed(symbol string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Contains(r.symbols, symbol)
+}
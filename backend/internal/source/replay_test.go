@@ -0,0 +1,69 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+func collectTrades(t *testing.T, r *ReplaySource) []models.TradeData {
+	t.Helper()
+
+	var trades []models.TradeData
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case trade, ok := <-r.Trades():
+			if !ok {
+				return trades
+			}
+			trades = append(trades, trade)
+		case <-timeout:
+			t.Fatal("Replay did not finish in time")
+		}
+	}
+}
+
+func TestReplaySource_FiltersSymbols(t *testing.T) {
+	replay := NewReplaySource("testdata/trades.ndjson", 0, false, []string{"AAPL"})
+	if err := replay.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	replay.Start()
+
+	trades := collectTrades(t, replay)
+	if len(trades) != 3 {
+		t.Fatalf("Expected 3 AAPL trades, got %d", len(trades))
+	}
+	for _, trade := range trades {
+		if trade.Symbol != "AAPL" {
+			t.Errorf("Expected only AAPL trades, got %s", trade.Symbol)
+		}
+	}
+	if trades[2].Timestamp != 1717421462000 {
+		t.Errorf("Expected original timestamp to be kept, got %d", trades[2].Timestamp)
+	}
+}
+
+func TestReplaySource_LoopShiftsTimestamps(t *testing.T) {
+	replay := NewReplaySource("testdata/trades.ndjson", 0, true, []string{"MSFT"})
+	if err := replay.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	replay.Start()
+	defer replay.Stop()
+
+	first := <-replay.Trades()
+	second := <-replay.Trades()
+	if second.Timestamp <= first.Timestamp {
+		t.Errorf("Expected looped trade to be later than %d, got %d", first.Timestamp, second.Timestamp)
+	}
+}
+
+func TestReplaySource_MissingFile(t *testing.T) {
+	replay := NewReplaySource("testdata/missing.ndjson", 1, false, []string{"AAPL"})
+	if err := replay.Connect(); err == nil {
+		t.Fatal("Expected an error for a missing replay file")
+	}
+}
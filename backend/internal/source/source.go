@@ -0,0 +1,56 @@
+package source
+
+import (
+	"fmt"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// TradeSource is a market data feed that produces trades for a set of symbols
+type TradeSource interface {
+	// Name returns the identifier of the source (e.g. "finnhub")
+	Name() string
+
+	// Connect opens the underlying feed and subscribes to the configured symbols
+	Connect() error
+
+	// Start begins delivering trades on the Trades channel
+	Start()
+
+	// Stop closes the feed
+	Stop()
+
+	// Subscribe adds symbols to the feed
+	Subscribe(symbols ...string) error
+
+	// Unsubscribe removes symbols from the feed
+	Unsubscribe(symbols ...string) error
+
+	// Trades returns the stream of received trades
+	Trades() <-chan models.TradeData
+
+	// Status returns the current connection status
+	Status() Status
+}
+
+// Status describes the health of a trade source
+type Status struct {
+	Source       string    `json:"source"`
+	Connected    bool      `json:"connected"`
+	LastPingTime time.Time `json:"last_ping"`
+	Symbols      []string  `json:"symbols"`
+}
+
+// New creates the trade source selected by the configuration
+func New(cfg *config.Env, symbols []string) (TradeSource, error) {
+	switch cfg.DATA_SOURCE {
+	case "finnhub":
+		return NewFinnhubClient(cfg, symbols), nil
+	case "replay":
+		return NewReplaySource(cfg.REPLAY_FILE, cfg.REPLAY_SPEED, cfg.REPLAY_LOOP, symbols), nil
+	default:
+		return nil, fmt.Errorf("unknown data source %q", cfg.DATA_SOURCE)
+	}
+}
@@ -0,0 +1,333 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// maxResumeCandles caps the closed candles replayed per symbol on resume
+const maxResumeCandles = 1000
+
+// CandleProvider supplies the snapshots and replayed candles sent when a
+// client connects or resumes
+type CandleProvider interface {
+	Snapshot(symbol string, interval models.Interval, limit int) (*models.Snapshot, error)
+	ReplaySince(symbol string, interval models.Interval, since time.Time, limit int) ([]models.BroadcastMessage, uint64, bool, error)
+}
+
+// update is a broadcast message with its shared JSON encoding
+type update struct {
+	msg  *models.BroadcastMessage
+	data []byte
+}
+
+// client is a connected Server-Sent Events stream
+type client struct {
+	subscriptions map[models.Subscription]bool
+	updates       chan update
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// subscribesTo reports whether the client subscribed to symbol
+func (c *client) subscribesTo(symbol string) bool {
+	for key := range c.subscriptions {
+		if key.Symbol == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// close ends the client's stream
+func (c *client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// Hub delivers candle updates to Server-Sent Events clients of /stream
+type Hub struct {
+	clients      map[*client]bool
+	clientsMutex sync.RWMutex
+	symbols      map[string]bool
+	candles      CandleProvider
+	snapshotSize int
+	queueSize    int
+	pingInterval time.Duration
+
+	droppedMessages atomic.Int64
+}
+
+// NewHub creates a hub accepting subscriptions to the given symbols
+func NewHub(cfg *config.Env, symbols []string, candles CandleProvider) *Hub {
+	h := &Hub{
+		clients:      make(map[*client]bool),
+		symbols:      make(map[string]bool),
+		candles:      candles,
+		snapshotSize: cfg.WS_SNAPSHOT_CANDLES,
+		queueSize:    cfg.SSE_SEND_QUEUE,
+		pingInterval: cfg.SSE_PING_INTERVAL,
+	}
+	for _, symbol := range symbols {
+		h.symbols[symbol] = true
+	}
+	return h
+}
+
+// HandleStream streams the updates of the symbols and interval given in the
+// query. New clients first receive a snapshot of every symbol; clients that
+// reconnect with Last-Event-ID receive the candles they missed instead.
+func (h *Hub) HandleStream(w http.ResponseWriter, r *http.Request) {
+	symbols, interval, err := h.parseSubscription(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resumeFrom time.Time
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		millis, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		// Go back one bucket so candles of other symbols that closed after the
+		// last event are not missed
+		resumeFrom = time.UnixMilli(millis).Add(-interval.Duration())
+	}
+
+	c := &client{
+		subscriptions: make(map[models.Subscription]bool),
+		updates:       make(chan update, h.queueSize),
+		done:          make(chan struct{}),
+	}
+	for _, symbol := range symbols {
+		c.subscriptions[models.Subscription{Symbol: symbol, Interval: interval}] = true
+	}
+
+	// Register before reading the initial state so no update is missed; the
+	// ones it already covers are skipped by sequence number
+	h.clientsMutex.Lock()
+	h.clients[c] = true
+	h.clientsMutex.Unlock()
+	defer func() {
+		h.clientsMutex.Lock()
+		delete(h.clients, c)
+		h.clientsMutex.Unlock()
+		c.close()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	seqs := make(map[string]uint64)
+	for _, symbol := range symbols {
+		seq, err := h.writeInitialState(w, symbol, interval, resumeFrom)
+		if err != nil {
+			log.Printf("Failed to send initial state of %s to SSE client: %v", symbol, err)
+			return
+		}
+		seqs[symbol] = seq
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(h.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case u := <-c.updates:
+			if u.msg.Resync {
+				seq, err := h.writeSnapshot(w, u.msg.Candle.Symbol, interval, true)
+				if err != nil {
+					log.Printf("Failed to resync %s for SSE client: %v", u.msg.Candle.Symbol, err)
+					return
+				}
+				seqs[u.msg.Candle.Symbol] = seq
+				break
+			}
+			if u.msg.Seq <= seqs[u.msg.Candle.Symbol] {
+				continue
+			}
+			if err := writeEvent(w, "update", eventID(u.msg.Candle), u.data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			// Comments keep proxies from closing idle streams
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-c.done:
+			log.Printf("Disconnecting slow SSE client")
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeInitialState writes a snapshot of a symbol, or the candles closed
+// since resumeFrom followed by the live candle, and returns the sequence
+// number they reflect. A resume spanning too many candles gets a snapshot
+// flagged as a reset instead.
+func (h *Hub) writeInitialState(w http.ResponseWriter, symbol string, interval models.Interval, resumeFrom time.Time) (uint64, error) {
+	if !resumeFrom.IsZero() {
+		updates, seq, ok, err := h.candles.ReplaySince(symbol, interval, resumeFrom, maxResumeCandles)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			for i := range updates {
+				data, err := json.Marshal(&updates[i])
+				if err != nil {
+					return 0, err
+				}
+				if err := writeEvent(w, "update", eventID(updates[i].Candle), data); err != nil {
+					return 0, err
+				}
+			}
+			return seq, nil
+		}
+	}
+
+	return h.writeSnapshot(w, symbol, interval, !resumeFrom.IsZero())
+}
+
+// writeSnapshot writes a snapshot of a symbol and returns its seq
+func (h *Hub) writeSnapshot(w http.ResponseWriter, symbol string, interval models.Interval, reset bool) (uint64, error) {
+	snapshot, err := h.candles.Snapshot(symbol, interval, h.snapshotSize)
+	if err != nil {
+		return 0, err
+	}
+	snapshot.Reset = reset
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, err
+	}
+	return snapshot.Seq, writeEvent(w, "snapshot", snapshotID(snapshot), data)
+}
+
+// BroadcastToClients queues an update for every subscribed client. A client
+// whose queue is full loses live updates; one that would lose a closed
+// candle or a resync is disconnected so that it resumes from its last event.
+// A resync reaches every client subscribed to the symbol and is sent as a
+// reset snapshot.
+func (h *Hub) BroadcastToClients(msg *models.BroadcastMessage) {
+	key := models.Subscription{Symbol: msg.Candle.Symbol, Interval: msg.Candle.Interval}
+
+	var data []byte
+	h.clientsMutex.RLock()
+	defer h.clientsMutex.RUnlock()
+	for c := range h.clients {
+		if !c.subscriptions[key] && !(msg.Resync && c.subscribesTo(msg.Candle.Symbol)) {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(msg); err != nil {
+				log.Printf("Failed to marshal message: %v", err)
+				return
+			}
+		}
+
+		select {
+		case c.updates <- update{msg: msg, data: data}:
+		default:
+			h.droppedMessages.Add(1)
+			if msg.UpdateType == models.Closed || msg.Resync {
+				c.close()
+			}
+		}
+	}
+}
+
+// GetActiveClientsCount returns the number of connected stream clients
+func (h *Hub) GetActiveClientsCount() int {
+	h.clientsMutex.RLock()
+	defer h.clientsMutex.RUnlock()
+	return len(h.clients)
+}
+
+// DroppedMessages returns the number of updates dropped for slow clients
+func (h *Hub) DroppedMessages() int64 {
+	return h.droppedMessages.Load()
+}
+
+// parseSubscription reads the symbols and interval parameters. Symbols may be
+// repeated or comma separated; the interval defaults to one minute.
+func (h *Hub) parseSubscription(r *http.Request) ([]string, models.Interval, error) {
+	params := r.URL.Query()
+
+	var symbols []string
+	for _, value := range append(params["symbol"], params["symbols"]...) {
+		for _, symbol := range strings.Split(value, ",") {
+			if symbol = strings.TrimSpace(symbol); symbol != "" {
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+	if len(symbols) == 0 {
+		return nil, "", fmt.Errorf("symbols are required")
+	}
+	for _, symbol := range symbols {
+		if !h.symbols[symbol] {
+			return nil, "", fmt.Errorf("unknown symbol %q", symbol)
+		}
+	}
+
+	interval := models.Interval1m
+	if value := params.Get("interval"); value != "" {
+		parsed, err := models.ParseInterval(value)
+		if err != nil {
+			return nil, "", err
+		}
+		interval = parsed
+	}
+	return symbols, interval, nil
+}
+
+// writeEvent writes a single event. Events without an id leave the client's
+// last event ID unchanged.
+func writeEvent(w http.ResponseWriter, event, id string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// eventID identifies an event by the start of its candle in Unix milliseconds
+func eventID(candle *models.Candle) string {
+	return strconv.FormatInt(candle.Timestamp.UnixMilli(), 10)
+}
+
+// snapshotID identifies a snapshot by its most recent candle
+func snapshotID(snapshot *models.Snapshot) string {
+	switch {
+	case snapshot.Live != nil:
+		return eventID(snapshot.Live)
+	case len(snapshot.Candles) > 0:
+		return eventID(&snapshot.Candles[len(snapshot.Candles)-1])
+	default:
+		return ""
+	}
+}
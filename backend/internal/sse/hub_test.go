@@ -0,0 +1,166 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// fakeCandles serves a fixed live candle and stored candles
+type fakeCandles struct {
+	live   *models.Candle
+	stored []models.Candle
+	from   time.Time
+}
+
+func (f *fakeCandles) Snapshot(symbol string, interval models.Interval, limit int) (*models.Snapshot, error) {
+	return &models.Snapshot{Type: models.SnapshotType, Symbol: symbol, Interval: interval, Seq: 5, Live: f.live}, nil
+}
+
+func (f *fakeCandles) ReplaySince(symbol string, interval models.Interval, since time.Time, limit int) ([]models.BroadcastMessage, uint64, bool, error) {
+	f.from = since
+	var updates []models.BroadcastMessage
+	for i := range f.stored {
+		updates = append(updates, models.BroadcastMessage{UpdateType: models.Closed, Candle: &f.stored[i]})
+	}
+	if f.live != nil {
+		updates = append(updates, models.BroadcastMessage{UpdateType: models.Live, Candle: f.live, Seq: 5})
+	}
+	return updates, 5, true, nil
+}
+
+// event is a parsed Server-Sent Event
+type event struct {
+	id, name, data string
+}
+
+// readEvent reads the next event, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) event {
+	t.Helper()
+
+	var ev event
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.name != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// openStream starts a hub behind a test server and opens a stream
+func openStream(t *testing.T, hub *Hub, query, lastEventID string) *bufio.Reader {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(hub.HandleStream))
+	t.Cleanup(server.Close)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"?"+query, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func testConfig() *config.Env {
+	return &config.Env{WS_SNAPSHOT_CANDLES: 10, SSE_SEND_QUEUE: 16, SSE_PING_INTERVAL: time.Minute}
+}
+
+func TestHub_SnapshotThenUpdates(t *testing.T) {
+	bucket := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	candles := &fakeCandles{live: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket}}
+	hub := NewHub(testConfig(), []string{"AAPL", "MSFT"}, candles)
+	reader := openStream(t, hub, "symbols=AAPL", "")
+
+	snapshot := readEvent(t, reader)
+	if snapshot.name != "snapshot" || snapshot.id != "1717423200000" || !strings.Contains(snapshot.data, `"seq":5`) {
+		t.Fatalf("Unexpected snapshot event: %+v", snapshot)
+	}
+
+	// Updates already covered by the snapshot and other symbols are skipped
+	hub.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 5, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket}})
+	hub.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 1, Candle: &models.Candle{Symbol: "MSFT", Interval: models.Interval1m, Timestamp: bucket}})
+	hub.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Closed, Seq: 6, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket}})
+
+	update := readEvent(t, reader)
+	if update.name != "update" || !strings.Contains(update.data, `"update_type":"closed"`) || !strings.Contains(update.data, `"seq":6`) {
+		t.Errorf("Unexpected update event: %+v", update)
+	}
+}
+
+func TestHub_ResyncSendsResetSnapshot(t *testing.T) {
+	bucket := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	hub := NewHub(testConfig(), []string{"AAPL"}, &fakeCandles{})
+	reader := openStream(t, hub, "symbols=AAPL", "")
+	readEvent(t, reader)
+
+	// The ingest node restarted, so seqs start again below the snapshot's
+	hub.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 1, Resync: true, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket}})
+	reset := readEvent(t, reader)
+	if reset.name != "snapshot" || !strings.Contains(reset.data, `"reset":true`) {
+		t.Fatalf("Expected a reset snapshot, got %+v", reset)
+	}
+
+	hub.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Closed, Seq: 6, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket}})
+	if update := readEvent(t, reader); update.name != "update" || !strings.Contains(update.data, `"seq":6`) {
+		t.Errorf("Unexpected update event: %+v", update)
+	}
+}
+
+func TestHub_ResumesFromLastEventID(t *testing.T) {
+	bucket := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	candles := &fakeCandles{
+		live:   &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket.Add(2 * time.Minute)},
+		stored: []models.Candle{{Symbol: "AAPL", Interval: models.Interval1m, Timestamp: bucket.Add(time.Minute)}},
+	}
+	hub := NewHub(testConfig(), []string{"AAPL"}, candles)
+	reader := openStream(t, hub, "symbol=AAPL&interval=1m", "1717423200000")
+
+	closed := readEvent(t, reader)
+	if closed.name != "update" || closed.id != "1717423260000" || !strings.Contains(closed.data, `"update_type":"closed"`) {
+		t.Errorf("Unexpected closed event: %+v", closed)
+	}
+	live := readEvent(t, reader)
+	if live.id != "1717423320000" || !strings.Contains(live.data, `"update_type":"live"`) {
+		t.Errorf("Unexpected live event: %+v", live)
+	}
+
+	// Resuming goes back one bucket to cover candles of other symbols
+	if !candles.from.Equal(bucket.Add(-time.Minute)) {
+		t.Errorf("Expected stored candles from %s, got %s", bucket.Add(-time.Minute), candles.from)
+	}
+}
+
+func TestHub_RejectsUnknownSymbols(t *testing.T) {
+	hub := NewHub(testConfig(), []string{"AAPL"}, &fakeCandles{})
+
+	rec := httptest.NewRecorder()
+	hub.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/stream?symbols=NOPE", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/models"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorPosition is the last candle of a page, used for keyset pagination,
+// and the order the page was read in
+type cursorPosition struct {
+	Timestamp  int64 `json:"t"`
+	ID         uint  `json:"id"`
+	Descending bool  `json:"d,omitempty"`
+}
+
+// encodeCursor returns an opaque cursor pointing after candle in the given
+// order
+func encodeCursor(candle models.Candle, descending bool) string {
+	data, _ := json.Marshal(cursorPosition{
+		Timestamp:  candle.Timestamp.UnixNano(),
+		ID:         candle.ID,
+		Descending: descending,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor for a query in the
+// given order. A cursor of the other order is rejected, since it would
+// silently skip to the wrong page.
+func decodeCursor(cursor string, descending bool) (cursorPosition, error) {
+	var position cursorPosition
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return position, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &position); err != nil || position.Timestamp == 0 {
+		return position, ErrInvalidCursor
+	}
+	if position.Descending != descending {
+		return position, fmt.Errorf("%w: it was issued for the other order", ErrInvalidCursor)
+	}
+	return position, nil
+}
+
+// apply restricts a query to candles after the cursor in the given order.
+// The time is bound in UTC like stored candles, since SQLite compares times
+// as text.
+func (p cursorPosition) apply(tx *gorm.DB, descending bool) *gorm.DB {
+	timestamp := time.Unix(0, p.Timestamp).UTC()
+	if descending {
+		return tx.Where("timestamp < ? OR (timestamp = ? AND id < ?)", timestamp, timestamp, p.ID)
+	}
+	return tx.Where("timestamp > ? OR (timestamp = ? AND id > ?)", timestamp, timestamp, p.ID)
+}
+
+// follows reports whether a candle comes after the cursor in the given order
+func (p cursorPosition) follows(candle *models.Candle, descending bool) bool {
+	timestamp := candle.Timestamp.UnixNano()
+	if descending {
+		return timestamp < p.Timestamp || (timestamp == p.Timestamp && candle.ID < p.ID)
+	}
+	return timestamp > p.Timestamp || (timestamp == p.Timestamp && candle.ID > p.ID)
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"stock-market-websocket/internal/database"
+	"stock-market-websocket/internal/models"
+)
+
+// Gorm stores candles in the candles table of a Postgres or SQLite database
+type Gorm struct {
+	db *gorm.DB
+
+	// Read intervals above one minute from TimescaleDB continuous aggregates
+	aggregates bool
+}
+
+// NewGorm creates a store on a migrated database
+func NewGorm(db *gorm.DB) *Gorm {
+	return &Gorm{db: db}
+}
+
+// NewTimescale creates a store on a TimescaleDB database set up by
+// database.Connect. Candles are written to the candles hypertable, and
+// intervals above one minute are read from their continuous aggregates.
+func NewTimescale(db *gorm.DB) *Gorm {
+	return &Gorm{db: db, aggregates: true}
+}
+
+// Name identifies the store by its SQL dialect
+func (s *Gorm) Name() string {
+	return s.db.Dialector.Name()
+}
+
+// Save inserts a new candle and sets its ID
+func (s *Gorm) Save(candle *models.Candle) error {
+	candle.Timestamp = candle.Timestamp.UTC()
+	err := s.db.Create(candle).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrDuplicateCandle
+	}
+	return err
+}
+
+// Upsert inserts candles in one statement, overwriting stored candles with
+// the same key
+func (s *Gorm) Upsert(candles []*models.Candle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+	return s.upsert(candles).Error
+}
+
+// upsert builds the multi-row upsert of candles
+func (s *Gorm) upsert(candles []*models.Candle) *gorm.DB {
+	// SQLite stores times as text, so they are written in one zone to keep
+	// range comparisons ordered
+	rows := make([]models.Candle, len(candles))
+	for i, candle := range candles {
+		rows[i] = *candle
+		rows[i].Timestamp = candle.Timestamp.UTC()
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "symbol"}, {Name: "interval"}, {Name: "timestamp"}},
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "volume"}),
+	}).Create(&rows)
+}
+
+// Range returns a page of candles and the cursor of the next page
+func (s *Gorm) Range(query models.CandleQuery) ([]models.Candle, string, error) {
+	tx := s.candles(query.Interval).Where(`symbol = ? AND "interval" = ?`, query.Symbol, query.Interval)
+	if !query.From.IsZero() {
+		tx = tx.Where("timestamp >= ?", query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		tx = tx.Where("timestamp < ?", query.To.UTC())
+	}
+
+	if query.Cursor != "" {
+		position, err := decodeCursor(query.Cursor, query.Descending)
+		if err != nil {
+			return nil, "", err
+		}
+		tx = position.apply(tx, query.Descending)
+	}
+
+	if query.Descending {
+		tx = tx.Order("timestamp desc, id desc")
+	} else {
+		tx = tx.Order("timestamp asc, id asc")
+	}
+
+	// Fetch one extra row to find out whether another page exists
+	if query.Limit > 0 {
+		tx = tx.Limit(query.Limit + 1)
+	}
+
+	var candles []models.Candle
+	if err := tx.Find(&candles).Error; err != nil {
+		return nil, "", err
+	}
+	return page(candles, query)
+}
+
+// Latest returns the newest candle of a symbol and interval
+func (s *Gorm) Latest(symbol string, interval models.Interval) (*models.Candle, error) {
+	var candles []models.Candle
+	err := s.candles(interval).Where(`symbol = ? AND "interval" = ?`, symbol, interval).
+		Order("timestamp desc").
+		Limit(1).
+		Find(&candles).Error
+	if err != nil || len(candles) == 0 {
+		return nil, err
+	}
+	return &candles[0], nil
+}
+
+// Stream reads matching candles one row at a time so memory use does not
+// grow with the size of the table
+func (s *Gorm) Stream(query models.HistoryQuery, fn func(*models.Candle) error) error {
+	tx := s.candles(query.Interval).Where(`"interval" = ?`, query.Interval)
+	if len(query.Symbols) > 0 {
+		tx = tx.Where("symbol IN ?", query.Symbols)
+	}
+	if !query.From.IsZero() {
+		tx = tx.Where("timestamp >= ?", query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		tx = tx.Where("timestamp < ?", query.To.UTC())
+	}
+
+	rows, err := tx.Order("symbol asc, timestamp asc").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var candle models.Candle
+		if err := s.db.ScanRows(rows, &candle); err != nil {
+			return err
+		}
+		if err := fn(&candle); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CountBefore counts the candles of an interval that start before a time
+func (s *Gorm) CountBefore(interval models.Interval, before time.Time) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.Candle{}).Where(`"interval" = ? AND timestamp < ?`, interval, before.UTC()).Count(&count).Error
+	return count, err
+}
+
+// DeleteBefore deletes the candles of an interval that start before a time
+func (s *Gorm) DeleteBefore(interval models.Interval, before time.Time) (int64, error) {
+	result := s.db.Where(`"interval" = ? AND timestamp < ?`, interval, before.UTC()).Delete(&models.Candle{})
+	return result.RowsAffected, result.Error
+}
+
+// Aggregates reports whether intervals above one minute are read from
+// continuous aggregates
+func (s *Gorm) Aggregates() bool {
+	return s.aggregates
+}
+
+// RefreshAggregates recomputes the continuous aggregates over a time range,
+// which their refresh policies only cover for recent buckets
+func (s *Gorm) RefreshAggregates(from, to time.Time) error {
+	if !s.aggregates {
+		return nil
+	}
+	// A refresh only covers whole buckets, so the range is widened by a day
+	// on both sides to include the daily buckets it overlaps
+	start := from.UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	end := to.UTC().Truncate(24*time.Hour).AddDate(0, 0, 2)
+	for _, interval := range models.Intervals[1:] {
+		err := s.db.Exec("CALL refresh_continuous_aggregate(?::regclass, ?::timestamptz, ?::timestamptz)", database.AggregateView(interval), start, end).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// candles returns the table holding the candles of an interval. Continuous
+// aggregates are exposed with the columns of the candles table, so the same
+// conditions apply to both.
+func (s *Gorm) candles(interval models.Interval) *gorm.DB {
+	if !s.aggregates || interval == models.Interval1m {
+		return s.db.Model(&models.Candle{})
+	}
+	view := s.db.Table(database.AggregateView(interval)).
+		Select(`0 AS id, symbol, ? AS "interval", bucket AS timestamp, open, high, low, close, volume`, interval)
+	return s.db.Table("(?) AS candles", view)
+}
+
+// page trims a result fetched with one extra row to the query's limit and
+// returns the cursor of the next page in the query's order
+func page(candles []models.Candle, query models.CandleQuery) ([]models.Candle, string, error) {
+	if query.Limit <= 0 || len(candles) <= query.Limit {
+		return candles, "", nil
+	}
+	candles = candles[:query.Limit]
+	return candles, encodeCursor(candles[len(candles)-1], query.Descending), nil
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// Memory keeps candles in process memory. It needs no database server but
+// loses every candle on restart, so it suits tests and short-lived
+// deployments.
+type Memory struct {
+	mutex   sync.RWMutex
+	nextID  uint
+	candles map[models.Subscription][]models.Candle // Ordered by timestamp
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{candles: make(map[models.Subscription][]models.Candle)}
+}
+
+// Name identifies the store
+func (s *Memory) Name() string {
+	return "memory"
+}
+
+// Save inserts a new candle and sets its ID
+func (s *Memory) Save(candle *models.Candle) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := models.Subscription{Symbol: candle.Symbol, Interval: candle.Interval}
+	i, found := s.find(key, candle.Timestamp)
+	if found {
+		return ErrDuplicateCandle
+	}
+	s.nextID++
+	candle.ID = s.nextID
+	s.candles[key] = slices.Insert(s.candles[key], i, *candle)
+	return nil
+}
+
+// Upsert inserts candles, overwriting stored candles with the same key. An
+// overwritten candle keeps its ID.
+func (s *Memory) Upsert(candles []*models.Candle) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, candle := range candles {
+		key := models.Subscription{Symbol: candle.Symbol, Interval: candle.Interval}
+		stored := *candle
+		i, found := s.find(key, candle.Timestamp)
+		if found {
+			stored.ID = s.candles[key][i].ID
+			s.candles[key][i] = stored
+			continue
+		}
+		s.nextID++
+		stored.ID = s.nextID
+		s.candles[key] = slices.Insert(s.candles[key], i, stored)
+	}
+	return nil
+}
+
+// Range returns a page of candles and the cursor of the next page
+func (s *Memory) Range(query models.CandleQuery) ([]models.Candle, string, error) {
+	var position *cursorPosition
+	if query.Cursor != "" {
+		decoded, err := decodeCursor(query.Cursor, query.Descending)
+		if err != nil {
+			return nil, "", err
+		}
+		position = &decoded
+	}
+
+	s.mutex.RLock()
+	stored := s.between(models.Subscription{Symbol: query.Symbol, Interval: query.Interval}, query.From, query.To)
+	s.mutex.RUnlock()
+
+	if query.Descending {
+		slices.Reverse(stored)
+	}
+	candles := make([]models.Candle, 0, len(stored))
+	for i := range stored {
+		if position != nil && !position.follows(&stored[i], query.Descending) {
+			continue
+		}
+		candles = append(candles, stored[i])
+		if query.Limit > 0 && len(candles) > query.Limit {
+			break
+		}
+	}
+	return page(candles, query)
+}
+
+// Latest returns the newest candle of a symbol and interval
+func (s *Memory) Latest(symbol string, interval models.Interval) (*models.Candle, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stored := s.candles[models.Subscription{Symbol: symbol, Interval: interval}]
+	if len(stored) == 0 {
+		return nil, nil
+	}
+	candle := stored[len(stored)-1]
+	return &candle, nil
+}
+
+// Stream calls fn for every matching candle. The candles are copied first so
+// that a slow fn does not hold up writes.
+func (s *Memory) Stream(query models.HistoryQuery, fn func(*models.Candle) error) error {
+	s.mutex.RLock()
+	var keys []models.Subscription
+	for key := range s.candles {
+		if key.Interval == query.Interval && (len(query.Symbols) == 0 || slices.Contains(query.Symbols, key.Symbol)) {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b models.Subscription) int {
+		return cmp.Compare(a.Symbol, b.Symbol)
+	})
+	var candles []models.Candle
+	for _, key := range keys {
+		candles = append(candles, s.between(key, query.From, query.To)...)
+	}
+	s.mutex.RUnlock()
+
+	for i := range candles {
+		if err := fn(&candles[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountBefore counts the candles of an interval that start before a time
+func (s *Memory) CountBefore(interval models.Interval, before time.Time) (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var count int64
+	for key := range s.candles {
+		if key.Interval == interval {
+			i, _ := s.find(key, before)
+			count += int64(i)
+		}
+	}
+	return count, nil
+}
+
+// DeleteBefore deletes the candles of an interval that start before a time
+func (s *Memory) DeleteBefore(interval models.Interval, before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var deleted int64
+	for key, stored := range s.candles {
+		if key.Interval != interval {
+			continue
+		}
+		i, _ := s.find(key, before)
+		deleted += int64(i)
+		s.candles[key] = slices.Clone(stored[i:])
+	}
+	return deleted, nil
+}
+
+// find returns the position of the candle of key starting at timestamp, or
+// where it would be inserted. The caller must hold s.mutex.
+func (s *Memory) find(key models.Subscription, timestamp time.Time) (int, bool) {
+	return slices.BinarySearchFunc(s.candles[key], timestamp, func(candle models.Candle, t time.Time) int {
+		return candle.Timestamp.Compare(t)
+	})
+}
+
+// between copies the candles of key from from (inclusive) to to (exclusive),
+// where zero times leave the range open. The caller must hold s.mutex.
+func (s *Memory) between(key models.Subscription, from, to time.Time) []models.Candle {
+	stored := s.candles[key]
+	start, end := 0, len(stored)
+	if !from.IsZero() {
+		start, _ = s.find(key, from)
+	}
+	if !to.IsZero() {
+		end, _ = s.find(key, to)
+	}
+	if start >= end {
+		return nil
+	}
+	return slices.Clone(stored[start:end])
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+)
+
+// ErrDuplicateCandle is returned when saving a candle whose symbol, interval
+// and bucket start are already stored
+var ErrDuplicateCandle = errors.New("duplicate candle")
+
+// CandleStore persists closed candles
+type CandleStore interface {
+	// Name identifies the implementation
+	Name() string
+	// Save inserts a new candle and sets its ID
+	Save(candle *models.Candle) error
+	// Upsert inserts candles, overwriting the stored candle of the same
+	// symbol, interval and bucket start so that re-processing trades
+	// converges on a single row
+	Upsert(candles []*models.Candle) error
+	// Range returns a page of candles and the cursor of the next page, which
+	// is empty when there are no more candles
+	Range(query models.CandleQuery) ([]models.Candle, string, error)
+	// Latest returns the newest candle of a symbol and interval, or nil when
+	// there is none
+	Latest(symbol string, interval models.Interval) (*models.Candle, error)
+	// Stream calls fn for every candle matching the query, ordered by symbol
+	// and time
+	Stream(query models.HistoryQuery, fn func(*models.Candle) error) error
+	// CountBefore returns how many candles of an interval start before a
+	// time
+	CountBefore(interval models.Interval, before time.Time) (int64, error)
+	// DeleteBefore deletes the candles of an interval that start before a
+	// time and returns how many were deleted
+	DeleteBefore(interval models.Interval, before time.Time) (int64, error)
+}
+
+// New creates the store selected by STORE. db is the connection opened by
+// database.Connect and is unused by the memory store.
+func New(cfg *config.Env, db *gorm.DB) (CandleStore, error) {
+	switch cfg.STORE {
+	case config.StoreMemory:
+		return NewMemory(), nil
+	case config.StorePostgres, config.StoreSQLite:
+		if db == nil {
+			return nil, fmt.Errorf("the %s store needs a database connection", cfg.STORE)
+		}
+		if cfg.TIMESCALE {
+			return NewTimescale(db), nil
+		}
+		return NewGorm(db), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.STORE)
+	}
+}
+
+// AggregateStore is implemented by stores that can derive the intervals
+// above one minute from the one minute candles rather than storing them
+type AggregateStore interface {
+	// Aggregates reports whether the intervals are derived
+	Aggregates() bool
+	// RefreshAggregates recomputes the derived candles over a time range
+	// after the one minute candles in it were rewritten
+	RefreshAggregates(from, to time.Time) error
+}
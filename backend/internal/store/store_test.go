@@ -0,0 +1,277 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"stock-market-websocket/internal/database"
+	"stock-market-websocket/internal/models"
+)
+
+// testStores returns every store that runs without a database server
+func testStores(t *testing.T) map[string]CandleStore {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "candles.db")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("Failed to migrate SQLite database: %v", err)
+	}
+
+	return map[string]CandleStore{
+		"memory": NewMemory(),
+		"sqlite": NewGorm(db),
+	}
+}
+
+// minuteCandles builds one-minute candles whose close is their minute
+func minuteCandles(symbol string, start time.Time, count int) []*models.Candle {
+	candles := make([]*models.Candle, count)
+	for i := range candles {
+		candles[i] = &models.Candle{
+			Symbol:    symbol,
+			Interval:  models.Interval1m,
+			Close:     float64(i),
+			Volume:    1,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return candles
+}
+
+func TestCandleStore_UpsertAndRange(t *testing.T) {
+	// Stored in the exchange zone, queried in UTC
+	location, _ := time.LoadLocation("America/New_York")
+	start := time.Date(2024, 6, 3, 9, 30, 0, 0, location)
+
+	for name, candleStore := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := candleStore.Upsert(minuteCandles("AAPL", start, 5)); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+			updated := &models.Candle{Symbol: "AAPL", Interval: models.Interval1m, Close: 42, Timestamp: start.Add(2 * time.Minute)}
+			if err := candleStore.Upsert([]*models.Candle{updated}); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+
+			query := models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m, From: start.Add(time.Minute).UTC(), To: start.Add(4 * time.Minute).UTC(), Limit: 2}
+			first, cursor, err := candleStore.Range(query)
+			if err != nil {
+				t.Fatalf("Range failed: %v", err)
+			}
+			if len(first) != 2 || first[0].Close != 1 || first[1].Close != 42 || cursor == "" {
+				t.Fatalf("Unexpected first page %+v (cursor %q)", first, cursor)
+			}
+
+			query.Cursor = cursor
+			second, cursor, err := candleStore.Range(query)
+			if err != nil {
+				t.Fatalf("Range failed: %v", err)
+			}
+			if len(second) != 1 || second[0].Close != 3 || cursor != "" {
+				t.Errorf("Unexpected second page %+v (cursor %q)", second, cursor)
+			}
+
+			query = models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m, Limit: 1, Descending: true}
+			newest, _, err := candleStore.Range(query)
+			if err != nil || len(newest) != 1 || newest[0].Close != 4 {
+				t.Errorf("Expected the newest candle first, got %+v (%v)", newest, err)
+			}
+		})
+	}
+}
+
+func TestCandleStore_SaveRejectsDuplicates(t *testing.T) {
+	start := time.Date(2024, 6, 3, 13, 30, 0, 0, time.UTC)
+
+	for name, candleStore := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			candle := &models.Candle{Symbol: "MSFT", Interval: models.Interval1m, Close: 1, Timestamp: start}
+			if err := candleStore.Save(candle); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			if candle.ID == 0 {
+				t.Error("Expected Save to set the candle ID")
+			}
+
+			duplicate := &models.Candle{Symbol: "MSFT", Interval: models.Interval1m, Close: 2, Timestamp: start}
+			if err := candleStore.Save(duplicate); !errors.Is(err, ErrDuplicateCandle) {
+				t.Errorf("Expected ErrDuplicateCandle, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCandleStore_LatestStreamAndDeleteBefore(t *testing.T) {
+	start := time.Date(2024, 6, 3, 13, 30, 0, 0, time.UTC)
+
+	for name, candleStore := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if latest, err := candleStore.Latest("AAPL", models.Interval1m); err != nil || latest != nil {
+				t.Fatalf("Expected no candle in an empty store, got %+v (%v)", latest, err)
+			}
+
+			candleStore.Upsert(minuteCandles("MSFT", start, 3))
+			candleStore.Upsert(minuteCandles("AAPL", start, 3))
+			candleStore.Upsert([]*models.Candle{{Symbol: "AAPL", Interval: models.Interval1h, Timestamp: start}})
+
+			latest, err := candleStore.Latest("AAPL", models.Interval1m)
+			if err != nil || latest == nil || latest.Close != 2 {
+				t.Errorf("Expected the newest AAPL candle, got %+v (%v)", latest, err)
+			}
+
+			var streamed []string
+			err = candleStore.Stream(models.HistoryQuery{Interval: models.Interval1m, From: start.Add(time.Minute)}, func(candle *models.Candle) error {
+				streamed = append(streamed, fmt.Sprintf("%s:%.0f", candle.Symbol, candle.Close))
+				return nil
+			})
+			if want := "AAPL:1,AAPL:2,MSFT:1,MSFT:2"; err != nil || strings.Join(streamed, ",") != want {
+				t.Errorf("Expected %s, got %v (%v)", want, streamed, err)
+			}
+
+			if count, err := candleStore.CountBefore(models.Interval1m, start.Add(2*time.Minute)); err != nil || count != 4 {
+				t.Errorf("Expected 4 candles before the cutoff, got %d (%v)", count, err)
+			}
+			deleted, err := candleStore.DeleteBefore(models.Interval1m, start.Add(2*time.Minute))
+			if err != nil || deleted != 4 {
+				t.Errorf("Expected 4 deleted candles, got %d (%v)", deleted, err)
+			}
+			if latest, _ := candleStore.Latest("AAPL", models.Interval1h); latest == nil {
+				t.Error("Expected candles of other intervals to be kept")
+			}
+		})
+	}
+}
+
+func TestGorm_UpsertStatement(t *testing.T) {
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open dry-run database: %v", err)
+	}
+
+	start := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+	sql := NewGorm(db).upsert(append(minuteCandles("AAPL", start, 1), minuteCandles("MSFT", start, 1)...)).Statement.SQL.String()
+	if strings.Count(sql, "),(") != 1 {
+		t.Errorf("Expected a single two-row insert, got %s", sql)
+	}
+	if !strings.Contains(sql, `ON CONFLICT ("symbol","interval","timestamp") DO UPDATE SET`) {
+		t.Errorf("Expected an upsert on the candle key, got %s", sql)
+	}
+	for _, column := range []string{"open", "high", "low", "close", "volume"} {
+		if !strings.Contains(sql, fmt.Sprintf(`"%s"="excluded"."%s"`, column, column)) {
+			t.Errorf("Expected %s to be overwritten, got %s", column, sql)
+		}
+	}
+}
+
+func TestTimescale_ReadsHigherIntervalsFromAggregates(t *testing.T) {
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open dry-run database: %v", err)
+	}
+	candleStore := NewTimescale(db)
+
+	var candles []models.Candle
+	sql := candleStore.candles(models.Interval1h).Where(`symbol = ?`, "AAPL").Find(&candles).Statement.SQL.String()
+	if !strings.Contains(sql, `FROM (SELECT 0 AS id, symbol, $1 AS "interval", bucket AS timestamp, open, high, low, close, volume FROM "candles_1h") AS candles`) {
+		t.Errorf("Expected 1h candles to be read from their aggregate, got %s", sql)
+	}
+
+	sql = candleStore.candles(models.Interval1m).Where(`symbol = ?`, "AAPL").Find(&candles).Statement.SQL.String()
+	if !strings.Contains(sql, `FROM "candles" WHERE`) {
+		t.Errorf("Expected 1m candles to be read from the hypertable, got %s", sql)
+	}
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	candle := models.Candle{ID: 42, Timestamp: time.Date(2024, 6, 3, 13, 30, 0, 0, time.UTC)}
+
+	position, err := decodeCursor(encodeCursor(candle, true), true)
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if position.ID != 42 || !time.Unix(0, position.Timestamp).Equal(candle.Timestamp) {
+		t.Errorf("Cursor did not round trip: %+v", position)
+	}
+}
+
+func TestCandleStore_RejectsCursorOfOtherOrder(t *testing.T) {
+	start := time.Date(2024, 6, 3, 13, 30, 0, 0, time.UTC)
+	for name, candleStore := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			candleStore.Upsert(minuteCandles("AAPL", start, 3))
+
+			query := models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m, Limit: 1, Descending: true}
+			_, next, err := candleStore.Range(query)
+			if err != nil || next == "" {
+				t.Fatalf("Expected a next page cursor, got %q (%v)", next, err)
+			}
+
+			query.Cursor, query.Descending = next, false
+			if _, _, err := candleStore.Range(query); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected a descending cursor to be rejected in ascending order, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCandleStore_FollowsCursorInNonUTCZone(t *testing.T) {
+	// SQLite compares times as text, so cursor times must be bound in the
+	// zone candles are stored in whatever the host zone is
+	local := time.Local
+	time.Local = time.FixedZone("IST", 5*60*60+30*60)
+	t.Cleanup(func() { time.Local = local })
+
+	start := time.Date(2024, 6, 3, 13, 30, 0, 0, time.UTC)
+	for name, candleStore := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := candleStore.Upsert(minuteCandles("AAPL", start, 5)); err != nil {
+				t.Fatalf("Upsert failed: %v", err)
+			}
+
+			for _, descending := range []bool{false, true} {
+				query := models.CandleQuery{Symbol: "AAPL", Interval: models.Interval1m, Limit: 2, Descending: descending}
+				var closes []float64
+				for page := 0; page < 5; page++ {
+					candles, cursor, err := candleStore.Range(query)
+					if err != nil {
+						t.Fatalf("Range failed: %v", err)
+					}
+					for _, candle := range candles {
+						closes = append(closes, candle.Close)
+					}
+					if cursor == "" {
+						break
+					}
+					query.Cursor = cursor
+				}
+
+				want := []float64{0, 1, 2, 3, 4}
+				if descending {
+					want = []float64{4, 3, 2, 1, 0}
+				}
+				if fmt.Sprint(closes) != fmt.Sprint(want) {
+					t.Errorf("Expected pages to hold %v (descending %t), got %v", want, descending, closes)
+				}
+			}
+		})
+	}
+}
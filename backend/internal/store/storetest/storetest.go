@@ -0,0 +1,29 @@
+// Package storetest provides candle stores for tests of code using the store
+package storetest
+
+import (
+	"time"
+
+	"stock-market-websocket/internal/store"
+)
+
+// AggregateMemory is a memory store that reports its intervals above one
+// minute as derived, like a TimescaleDB store
+type AggregateMemory struct {
+	*store.Memory
+}
+
+// NewAggregateMemory creates an empty in-memory store with aggregates
+func NewAggregateMemory() *AggregateMemory {
+	return &AggregateMemory{Memory: store.NewMemory()}
+}
+
+// Aggregates reports that the intervals above one minute are derived
+func (s *AggregateMemory) Aggregates() bool {
+	return true
+}
+
+// RefreshAggregates does nothing as no candles are derived in memory
+func (s *AggregateMemory) RefreshAggregates(from, to time.Time) error {
+	return nil
+}
@@ -1,32 +1,259 @@
 package websocket
 
 import (
-	"encoding/json"
 	"log"
+	"maps"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"stock-market-websocket/internal/config"
 	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/wire"
 )
 
+// maxResumeCandles caps the closed candles replayed per symbol when resuming
+// from a time
+const maxResumeCandles = 1000
+
+// CandleProvider supplies the snapshots and replayed updates sent when a
+// client subscribes or resumes
+type CandleProvider interface {
+	Snapshot(symbol string, interval models.Interval, limit int) (*models.Snapshot, error)
+	Replay(symbol string, interval models.Interval, epoch int64, seq uint64) ([]models.BroadcastMessage, uint64, bool)
+	ReplaySince(symbol string, interval models.Interval, since time.Time, limit int) ([]models.BroadcastMessage, uint64, bool, error)
+}
+
+// client is a connected frontend WebSocket. Frames are written by a
+// dedicated goroutine from a bounded queue so that a slow client cannot
+// stall the others.
+type client struct {
+	conn      *websocket.Conn
+	codec     wire.Codec
+	manager   *ClientManager
+	queue     *sendQueue
+	overflows int // Since the queue was last emptied
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Subscriptions waiting for their snapshot buffer updates in pending
+	stateMutex    sync.Mutex
+	subscriptions map[models.Subscription]bool
+	pending       map[models.Subscription][]*models.BroadcastMessage
+}
+
+// deliver queues an update for the client, or buffers it if the
+// subscription is still waiting for its snapshot. Updates are encoded through
+// frames, which shares the encoding between clients using the same codec.
+func (c *client) deliver(key models.Subscription, msg *models.BroadcastMessage, frames *frameCache) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	if buffered, ok := c.pending[key]; ok {
+		c.pending[key] = append(buffered, msg)
+		return
+	}
+	if !c.subscriptions[key] {
+		return
+	}
+
+	data, err := frames.encode(c.codec)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", c.codec.Name(), err)
+		return
+	}
+	c.enqueue(data, msg.UpdateType == models.Live)
+}
+
+// enqueue adds a frame to the send queue, disconnecting the client once it
+// has overflowed the queue too often without catching up in between, or
+// when a frame that cannot be dropped finds no room. The caller must hold
+// c.stateMutex.
+func (c *client) enqueue(data []byte, droppable bool) {
+	dropped, overflowed, refused := c.queue.push(outbound{data: data, droppable: droppable})
+	if dropped > 0 {
+		c.manager.droppedMessages.Add(int64(dropped))
+	}
+	if !overflowed {
+		return
+	}
+
+	c.overflows++
+	if refused {
+		log.Printf("Disconnecting slow client whose send queue is full of closed candles and control frames")
+		c.close(DisconnectSlowConsumer)
+		return
+	}
+	if c.overflows > c.manager.maxOverflows {
+		log.Printf("Disconnecting slow client after %d send queue overflows", c.overflows)
+		c.close(DisconnectSlowConsumer)
+	}
+}
+
+// send encodes and queues a control message or snapshot
+func (c *client) send(msg interface{}) error {
+	data, err := c.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	c.stateMutex.Lock()
+	c.enqueue(data, false)
+	c.stateMutex.Unlock()
+	return nil
+}
+
+// activate makes a pending subscription receive updates, first queueing the
+// buffered updates newer than seq so that they stay ahead of later ones
+func (c *client) activate(key models.Subscription, seq uint64) error {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	buffered, ok := c.pending[key]
+	if !ok {
+		return nil // Unsubscribed while the initial state was sent
+	}
+	delete(c.pending, key)
+	c.subscriptions[key] = true
+
+	for _, msg := range buffered {
+		if msg.Seq <= seq {
+			continue
+		}
+		data, err := c.codec.Encode(msg)
+		if err != nil {
+			return err
+		}
+		c.enqueue(data, msg.UpdateType == models.Live)
+	}
+	return nil
+}
+
+// writePump writes queued frames and heartbeat pings until the client is
+// closed
+func (c *client) writePump() {
+	ticker := time.NewTicker(c.manager.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.queue.notify:
+			if err := c.writeQueued(); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.writeFrame(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writeQueued writes every queued frame. A client that has been written
+// everything queued has caught up, so its overflows are forgiven.
+func (c *client) writeQueued() error {
+	for _, frame := range c.queue.drain() {
+		if err := c.writeFrame(c.codec.MessageType(), frame.data); err != nil {
+			return err
+		}
+	}
+
+	c.stateMutex.Lock()
+	if c.queue.len() == 0 {
+		c.overflows = 0
+	}
+	c.stateMutex.Unlock()
+	return nil
+}
+
+// writeFrame writes a single frame within the write timeout, closing the
+// client on failure
+func (c *client) writeFrame(messageType int, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.manager.writeTimeout))
+	err := c.conn.WriteMessage(messageType, data)
+	if err != nil {
+		log.Printf("Failed to write message to WebSocket: %v", err)
+		if isTimeout(err) {
+			c.close(DisconnectWriteTimeout)
+		} else {
+			c.close(DisconnectWriteError)
+		}
+	}
+	return err
+}
+
+// extendReadDeadline gives the client another ping interval plus the pong
+// timeout to show it is alive
+func (c *client) extendReadDeadline() {
+	c.conn.SetReadDeadline(time.Now().Add(c.manager.pingInterval + c.manager.pongTimeout))
+}
+
+// close stops the writer and closes the connection, which ends the reader.
+// Only the first reason is recorded.
+func (c *client) close(reason DisconnectReason) {
+	c.closeOnce.Do(func() {
+		c.manager.recordDisconnect(reason)
+		log.Printf("Client disconnected: %s", reason)
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
 // ClientManager manages WebSocket connections to frontend clients
 type ClientManager struct {
-	clients      map[*websocket.Conn]string
+	clients      map[*websocket.Conn]*client
 	clientsMutex sync.RWMutex
 	upgrader     websocket.Upgrader
+	symbols      map[string]bool
+	candles      CandleProvider
+	snapshotSize int
+
+	sendQueueSize int
+	writeTimeout  time.Duration
+	maxOverflows  int
+	pingInterval  time.Duration
+	pongTimeout   time.Duration
+
+	droppedMessages  atomic.Int64
+	disconnects      map[DisconnectReason]int64
+	disconnectsMutex sync.Mutex
+}
+
+// Stats reports dropped messages and disconnects by reason since startup
+type Stats struct {
+	DroppedMessages int64                      `json:"dropped_messages"`
+	Disconnects     map[DisconnectReason]int64 `json:"disconnects"`
 }
 
-// NewClientManager creates a new client manager
-func NewClientManager() *ClientManager {
-	return &ClientManager{
-		clients: make(map[*websocket.Conn]string),
+// NewClientManager creates a new client manager accepting subscriptions to
+// the given symbols
+func NewClientManager(cfg *config.Env, symbols []string, candles CandleProvider) *ClientManager {
+	cm := &ClientManager{
+		clients: make(map[*websocket.Conn]*client),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for now
 			},
+			Subprotocols: wire.Subprotocols(),
 		},
+		symbols:       make(map[string]bool),
+		candles:       candles,
+		snapshotSize:  cfg.WS_SNAPSHOT_CANDLES,
+		sendQueueSize: cfg.WS_SEND_QUEUE,
+		writeTimeout:  cfg.WS_WRITE_TIMEOUT,
+		maxOverflows:  cfg.WS_MAX_OVERFLOWS,
+		pingInterval:  cfg.WS_PING_INTERVAL,
+		pongTimeout:   cfg.WS_PONG_TIMEOUT,
+		disconnects:   make(map[DisconnectReason]int64),
+	}
+	for _, symbol := range symbols {
+		cm.symbols[symbol] = true
 	}
+	return cm
 }
 
 // HandleWebSocket handles new WebSocket connections from clients
@@ -37,47 +264,119 @@ func (cm *ClientManager) HandleWebSocket(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	defer ws.Close()
+
+	c := &client{
+		conn:          ws,
+		codec:         wire.ForSubprotocol(ws.Subprotocol()),
+		manager:       cm,
+		queue:         newSendQueue(cm.sendQueueSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[models.Subscription]bool),
+		pending:       make(map[models.Subscription][]*models.BroadcastMessage),
+	}
+	cm.clientsMutex.Lock()
+	cm.clients[ws] = c
+	cm.clientsMutex.Unlock()
+
+	// Any frame, including a pong, proves the client is still there
+	c.extendReadDeadline()
+	ws.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+
+	go c.writePump()
+
+	reason := DisconnectReadError
 	defer func() {
-		cm.clientsMutex.Lock()
-		delete(cm.clients, ws)
-		cm.clientsMutex.Unlock()
-		log.Printf("Client disconnected")
+		c.close(reason)
+		cm.removeClient(ws)
 	}()
 
 	for {
-		_, symbol, err := ws.ReadMessage()
+		_, frame, err := ws.ReadMessage()
 		if err != nil {
-			log.Printf("Failed to read message from WebSocket: %v", err)
+			reason = readErrorReason(err)
 			break
 		}
+		c.extendReadDeadline()
 
-		cm.clientsMutex.Lock()
-		cm.clients[ws] = string(symbol)
-		cm.clientsMutex.Unlock()
-
-		log.Printf("Client connected: %s", symbol)
+		if err := cm.handleFrame(c, frame); err != nil {
+			log.Printf("Failed to reply to WebSocket client: %v", err)
+			break
+		}
 	}
 }
 
-// BroadcastToClients broadcasts messages to connected clients
+// BroadcastToClients broadcasts messages to connected clients. A resync
+// replaces every subscription to the symbol with a reset snapshot.
 func (cm *ClientManager) BroadcastToClients(msg *models.BroadcastMessage) {
-	jsonMsg, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return
-	}
+	key := models.Subscription{Symbol: msg.Candle.Symbol, Interval: msg.Candle.Interval}
+	frames := newFrameCache(msg)
 
 	cm.clientsMutex.RLock()
 	defer cm.clientsMutex.RUnlock()
+	for _, c := range cm.clients {
+		if msg.Resync {
+			cm.resync(c, msg.Candle.Symbol)
+			continue
+		}
+		c.deliver(key, msg, frames)
+	}
+}
 
-	for conn, symbol := range cm.clients {
-		if symbol == msg.Candle.Symbol {
-			if err := conn.WriteMessage(websocket.TextMessage, jsonMsg); err != nil {
-				log.Printf("Failed to write message to WebSocket: %v", err)
-				conn.Close()
-				delete(cm.clients, conn)
+// resync sends a reset snapshot for every active subscription of a client
+// to symbol. Updates are buffered until then like for a new subscription.
+func (cm *ClientManager) resync(c *client, symbol string) {
+	var keys []models.Subscription
+	c.stateMutex.Lock()
+	for key := range c.subscriptions {
+		if key.Symbol == symbol {
+			delete(c.subscriptions, key)
+			c.pending[key] = nil
+			keys = append(keys, key)
+		}
+	}
+	c.stateMutex.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	go func() {
+		for _, key := range keys {
+			seq, err := cm.sendSnapshot(c, key, true)
+			if err == nil {
+				err = c.activate(key, seq)
+			}
+			if err != nil {
+				log.Printf("Failed to resync WebSocket client: %v", err)
+				return
 			}
 		}
+	}()
+}
+
+// removeClient forgets a connection
+func (cm *ClientManager) removeClient(conn *websocket.Conn) {
+	cm.clientsMutex.Lock()
+	delete(cm.clients, conn)
+	cm.clientsMutex.Unlock()
+}
+
+// recordDisconnect counts a disconnect by reason
+func (cm *ClientManager) recordDisconnect(reason DisconnectReason) {
+	cm.disconnectsMutex.Lock()
+	cm.disconnects[reason]++
+	cm.disconnectsMutex.Unlock()
+}
+
+// Stats returns the dropped message and disconnect counters
+func (cm *ClientManager) Stats() Stats {
+	cm.disconnectsMutex.Lock()
+	defer cm.disconnectsMutex.Unlock()
+	return Stats{
+		DroppedMessages: cm.droppedMessages.Load(),
+		Disconnects:     maps.Clone(cm.disconnects),
 	}
 }
 
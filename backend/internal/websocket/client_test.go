@@ -0,0 +1,339 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"stock-market-websocket/internal/config"
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/wire"
+)
+
+// fakeCandles serves a fixed snapshot and replay and, before returning the
+// snapshot, broadcasts updates to exercise buffering of a pending subscription
+type fakeCandles struct {
+	snapshot *models.Snapshot
+	before   func()
+	replay   []models.BroadcastMessage
+}
+
+func (f *fakeCandles) Snapshot(symbol string, interval models.Interval, limit int) (*models.Snapshot, error) {
+	if f.before != nil {
+		f.before()
+	}
+	snapshot := *f.snapshot
+	snapshot.Symbol, snapshot.Interval = symbol, interval
+	return &snapshot, nil
+}
+
+func (f *fakeCandles) Replay(symbol string, interval models.Interval, epoch int64, seq uint64) ([]models.BroadcastMessage, uint64, bool) {
+	if f.replay == nil {
+		return nil, f.snapshot.Seq, false
+	}
+	return f.replay, f.replay[len(f.replay)-1].Seq, true
+}
+
+func (f *fakeCandles) ReplaySince(symbol string, interval models.Interval, since time.Time, limit int) ([]models.BroadcastMessage, uint64, bool, error) {
+	updates, seq, ok := f.Replay(symbol, interval, 0, 0)
+	return updates, seq, ok, nil
+}
+
+// testConfig returns the client settings used by the tests
+func testConfig() *config.Env {
+	return &config.Env{
+		WS_SNAPSHOT_CANDLES: 2,
+		WS_SEND_QUEUE:       16,
+		WS_WRITE_TIMEOUT:    time.Second,
+		WS_MAX_OVERFLOWS:    1,
+		WS_PING_INTERVAL:    time.Minute,
+		WS_PONG_TIMEOUT:     time.Minute,
+	}
+}
+
+// dialTestServer starts a client manager behind a test server and connects
+// to it, requesting the given subprotocols
+func dialTestServer(t *testing.T, cm *ClientManager, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(cm.HandleWebSocket))
+	t.Cleanup(server.Close)
+
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestClientManager_SubscribeProtocol(t *testing.T) {
+	cm := NewClientManager(testConfig(), []string{"AAPL", "MSFT", "TSLA"}, nil)
+	conn := dialTestServer(t, cm)
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionSubscribe, Symbols: []string{"AAPL", "MSFT"}, Interval: models.Interval5m})
+	var ack models.ControlMessage
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatalf("Failed to read ack: %v", err)
+	}
+	if ack.Type != models.ControlAck || ack.Action != models.ActionSubscribe || ack.Interval != models.Interval5m {
+		t.Errorf("Unexpected ack: %+v", ack)
+	}
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionSubscribe, Symbols: []string{"NOPE"}})
+	var errorFrame models.ControlMessage
+	if err := conn.ReadJSON(&errorFrame); err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	if errorFrame.Type != models.ControlError || errorFrame.Error == "" {
+		t.Errorf("Expected error frame, got %+v", errorFrame)
+	}
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionUnsubscribe, Symbols: []string{"MSFT"}})
+	conn.ReadJSON(&models.ControlMessage{})
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionList})
+	var list models.ControlMessage
+	if err := conn.ReadJSON(&list); err != nil {
+		t.Fatalf("Failed to read list: %v", err)
+	}
+	if len(list.Subscriptions) != 1 || list.Subscriptions[0] != (models.Subscription{Symbol: "AAPL", Interval: models.Interval5m}) {
+		t.Fatalf("Unexpected subscriptions: %+v", list.Subscriptions)
+	}
+
+	// Only the subscribed symbol and interval are delivered
+	cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m}})
+	cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Candle: &models.Candle{Symbol: "MSFT", Interval: models.Interval5m}})
+	cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval5m, Close: 42}})
+
+	var update models.BroadcastMessage
+	if err := conn.ReadJSON(&update); err != nil {
+		t.Fatalf("Failed to read update: %v", err)
+	}
+	if update.Candle.Symbol != "AAPL" || update.Candle.Interval != models.Interval5m || update.Candle.Close != 42 {
+		t.Errorf("Unexpected update: %+v", update.Candle)
+	}
+}
+
+func TestClientManager_LegacySymbolFrame(t *testing.T) {
+	cm := NewClientManager(testConfig(), []string{"AAPL"}, nil)
+	conn := dialTestServer(t, cm)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("AAPL"))
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionList})
+
+	var list models.ControlMessage
+	if err := conn.ReadJSON(&list); err != nil {
+		t.Fatalf("Failed to read list: %v", err)
+	}
+	if len(list.Subscriptions) != 1 || list.Subscriptions[0] != (models.Subscription{Symbol: "AAPL", Interval: models.Interval1m}) {
+		t.Errorf("Unexpected subscriptions: %+v", list.Subscriptions)
+	}
+}
+
+func TestClientManager_SnapshotOnSubscribe(t *testing.T) {
+	snapshots := &fakeCandles{snapshot: &models.Snapshot{
+		Type:    models.SnapshotType,
+		Seq:     7,
+		Candles: []models.Candle{{Symbol: "AAPL", Close: 1}, {Symbol: "AAPL", Close: 2}},
+		Live:    &models.Candle{Symbol: "AAPL", Close: 3},
+	}}
+	cm := NewClientManager(testConfig(), []string{"AAPL"}, snapshots)
+	conn := dialTestServer(t, cm)
+
+	// Updates broadcast while the snapshot is built are buffered; those the
+	// snapshot already covers are dropped
+	snapshots.before = func() {
+		cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 7, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m}})
+		cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 8, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m}})
+	}
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionSubscribe, Symbols: []string{"AAPL"}})
+	conn.ReadJSON(&models.ControlMessage{})
+
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	var snapshot models.Snapshot
+	if err := json.Unmarshal(frame, &snapshot); err != nil {
+		t.Fatalf("Failed to decode snapshot: %v", err)
+	}
+	if snapshot.Type != models.SnapshotType || snapshot.Seq != 7 || len(snapshot.Candles) != 2 || snapshot.Live == nil {
+		t.Fatalf("Unexpected snapshot: %s", frame)
+	}
+
+	cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 9, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m}})
+	for _, want := range []uint64{8, 9} {
+		var update models.BroadcastMessage
+		if err := conn.ReadJSON(&update); err != nil {
+			t.Fatalf("Failed to read update: %v", err)
+		}
+		if update.Seq != want {
+			t.Errorf("Expected update seq %d, got %d", want, update.Seq)
+		}
+	}
+}
+
+func TestClientManager_ReapsClientsThatStopAnsweringPings(t *testing.T) {
+	cfg := testConfig()
+	cfg.WS_PING_INTERVAL = 50 * time.Millisecond
+	cfg.WS_PONG_TIMEOUT = 50 * time.Millisecond
+	cm := NewClientManager(cfg, []string{"AAPL"}, nil)
+	conn := dialTestServer(t, cm)
+
+	// The client answers pings until it goes silent
+	var silent atomic.Bool
+	conn.SetPingHandler(func(data string) error {
+		if silent.Load() {
+			return nil
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+	if cm.GetActiveClientsCount() != 1 {
+		t.Fatal("Expected responsive client to stay connected")
+	}
+
+	// A client that no longer answers pings is reaped
+	silent.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for cm.GetActiveClientsCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cm.GetActiveClientsCount() != 0 {
+		t.Fatal("Expected unresponsive client to be reaped")
+	}
+	if stats := cm.Stats(); stats.Disconnects[DisconnectPongTimeout] != 1 {
+		t.Errorf("Expected one pong timeout, got %+v", stats.Disconnects)
+	}
+	<-done
+}
+
+func TestClientManager_NegotiatesMsgpack(t *testing.T) {
+	cm := NewClientManager(testConfig(), []string{"AAPL"}, nil)
+	conn := dialTestServer(t, cm, "msgpack", "json")
+	if conn.Subprotocol() != "msgpack" {
+		t.Fatalf("Expected msgpack subprotocol, got %q", conn.Subprotocol())
+	}
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionSubscribe, Symbols: []string{"AAPL"}})
+	messageType, frame, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read ack: %v", err)
+	}
+	var ack map[string]interface{}
+	if messageType != websocket.BinaryMessage || msgpack.Unmarshal(frame, &ack) != nil || ack["type"] != models.ControlAck {
+		t.Fatalf("Expected binary msgpack ack, got type %d: %q", messageType, frame)
+	}
+}
+
+// countingCodec counts the messages it encodes
+type countingCodec struct {
+	wire.Codec
+	encoded *atomic.Int64
+}
+
+func (c countingCodec) Encode(v interface{}) ([]byte, error) {
+	c.encoded.Add(1)
+	return c.Codec.Encode(v)
+}
+
+func TestFrameCache_EncodesOncePerCodec(t *testing.T) {
+	var encoded atomic.Int64
+	codec := countingCodec{Codec: wire.JSON, encoded: &encoded}
+
+	frames := newFrameCache(&models.BroadcastMessage{UpdateType: models.Live, Candle: &models.Candle{Symbol: "AAPL"}})
+	for range 3 {
+		if _, err := frames.encode(codec); err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+	}
+	if encoded.Load() != 1 {
+		t.Errorf("Expected one encoding, got %d", encoded.Load())
+	}
+}
+
+func TestClientManager_Resume(t *testing.T) {
+	candles := &fakeCandles{snapshot: &models.Snapshot{Type: models.SnapshotType, Seq: 20}}
+	cm := NewClientManager(testConfig(), []string{"AAPL", "MSFT"}, candles)
+	conn := dialTestServer(t, cm)
+
+	// Missed updates are replayed when the server still has them
+	candles.replay = []models.BroadcastMessage{
+		{UpdateType: models.Closed, Seq: 11, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m}},
+		{UpdateType: models.Live, Seq: 12, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m}},
+	}
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionResume, Seqs: map[string]uint64{"AAPL": 10}})
+	var ack models.ControlMessage
+	if err := conn.ReadJSON(&ack); err != nil || ack.Type != models.ControlAck || ack.Action != models.ActionResume {
+		t.Fatalf("Expected resume ack, got %+v (%v)", ack, err)
+	}
+	for _, want := range []uint64{11, 12} {
+		var update models.BroadcastMessage
+		if err := conn.ReadJSON(&update); err != nil || update.Seq != want {
+			t.Fatalf("Expected replayed update %d, got %+v (%v)", want, update, err)
+		}
+	}
+
+	// Otherwise the client gets a reset snapshot
+	candles.replay = nil
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionResume, Symbols: []string{"MSFT"}, Since: 1717423200000})
+	conn.ReadJSON(&models.ControlMessage{})
+	var snapshot models.Snapshot
+	if err := conn.ReadJSON(&snapshot); err != nil || snapshot.Type != models.SnapshotType || !snapshot.Reset || snapshot.Symbol != "MSFT" {
+		t.Fatalf("Expected reset snapshot, got %+v (%v)", snapshot, err)
+	}
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionResume, Symbols: []string{"MSFT"}})
+	var errorFrame models.ControlMessage
+	if err := conn.ReadJSON(&errorFrame); err != nil || errorFrame.Type != models.ControlError {
+		t.Errorf("Expected error without seqs or since, got %+v (%v)", errorFrame, err)
+	}
+}
+
+func TestClientManager_ResyncSendsResetSnapshot(t *testing.T) {
+	snapshots := &fakeCandles{snapshot: &models.Snapshot{Type: models.SnapshotType, Seq: 7}}
+	cm := NewClientManager(testConfig(), []string{"AAPL"}, snapshots)
+	conn := dialTestServer(t, cm)
+
+	conn.WriteJSON(&models.ClientRequest{Action: models.ActionSubscribe, Symbols: []string{"AAPL"}, Interval: models.Interval5m})
+	conn.ReadJSON(&models.ControlMessage{})
+	conn.ReadJSON(&models.Snapshot{})
+
+	// The ingest node restarted; the resync of any interval covers the symbol
+	snapshots.snapshot = &models.Snapshot{Type: models.SnapshotType, Seq: 1}
+	cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 1, Resync: true, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval1m}})
+
+	var snapshot models.Snapshot
+	if err := conn.ReadJSON(&snapshot); err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if snapshot.Type != models.SnapshotType || !snapshot.Reset || snapshot.Seq != 1 || snapshot.Interval != models.Interval5m {
+		t.Fatalf("Expected a reset snapshot of the 5m subscription, got %+v", snapshot)
+	}
+
+	cm.BroadcastToClients(&models.BroadcastMessage{UpdateType: models.Live, Seq: 2, Candle: &models.Candle{Symbol: "AAPL", Interval: models.Interval5m}})
+	var update models.BroadcastMessage
+	if err := conn.ReadJSON(&update); err != nil || update.Seq != 2 {
+		t.Errorf("Expected the update after the resync, got %+v (err %v)", update, err)
+	}
+}
@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// DisconnectReason explains why a client connection ended
+type DisconnectReason string
+
+const (
+	DisconnectClientClosed DisconnectReason = "client_closed"
+	DisconnectPongTimeout  DisconnectReason = "pong_timeout"
+	DisconnectReadError    DisconnectReason = "read_error"
+	DisconnectWriteTimeout DisconnectReason = "write_timeout"
+	DisconnectWriteError   DisconnectReason = "write_error"
+	DisconnectSlowConsumer DisconnectReason = "slow_consumer"
+)
+
+// readErrorReason classifies the error that ended a client's read loop
+func readErrorReason(err error) DisconnectReason {
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived):
+		return DisconnectClientClosed
+	case isTimeout(err):
+		return DisconnectPongTimeout
+	default:
+		return DisconnectReadError
+	}
+}
+
+// isTimeout reports whether err is a deadline being exceeded
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
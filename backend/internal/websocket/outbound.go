@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"sync"
+
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/wire"
+)
+
+// frameCache encodes a broadcast message at most once per codec
+type frameCache struct {
+	msg     *models.BroadcastMessage
+	mutex   sync.Mutex
+	encoded map[wire.Codec][]byte
+}
+
+// newFrameCache creates an empty cache for a message
+func newFrameCache(msg *models.BroadcastMessage) *frameCache {
+	return &frameCache{msg: msg, encoded: make(map[wire.Codec][]byte)}
+}
+
+// encode returns the message encoded with codec
+func (f *frameCache) encode(codec wire.Codec) ([]byte, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if data, ok := f.encoded[codec]; ok {
+		return data, nil
+	}
+	data, err := codec.Encode(f.msg)
+	if err != nil {
+		return nil, err
+	}
+	f.encoded[codec] = data
+	return data, nil
+}
+
+// outbound is a frame waiting to be written to a client
+type outbound struct {
+	data      []byte
+	droppable bool // Live candle updates may be dropped when the client is slow
+}
+
+// sendQueue is a bounded queue of outbound frames. When full, the oldest
+// droppable frame is discarded; other frames are never dropped, so a frame
+// that cannot be dropped is refused when nothing else can make room.
+type sendQueue struct {
+	mutex    sync.Mutex
+	frames   []outbound
+	capacity int
+	notify   chan struct{}
+}
+
+// newSendQueue creates a queue holding up to capacity frames
+func newSendQueue(capacity int) *sendQueue {
+	return &sendQueue{
+		frames:   make([]outbound, 0, capacity),
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// push adds a frame and reports how many frames were dropped, whether the
+// queue overflowed and whether the frame was refused because it cannot be
+// dropped and the queue is full of frames that cannot be dropped either
+func (q *sendQueue) push(frame outbound) (dropped int, overflowed bool, refused bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.frames) >= q.capacity {
+		overflowed = true
+		if i := q.oldestDroppable(); i >= 0 {
+			q.frames = append(q.frames[:i], q.frames[i+1:]...)
+			dropped = 1
+		} else if frame.droppable {
+			return 1, true, false
+		} else {
+			return 0, true, true
+		}
+	}
+	q.frames = append(q.frames, frame)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return dropped, overflowed, false
+}
+
+// oldestDroppable returns the index of the oldest droppable frame, or -1
+func (q *sendQueue) oldestDroppable() int {
+	for i, frame := range q.frames {
+		if frame.droppable {
+			return i
+		}
+	}
+	return -1
+}
+
+// len returns the number of queued frames
+func (q *sendQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.frames)
+}
+
+// drain removes and returns every queued frame
+func (q *sendQueue) drain() []outbound {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	frames := q.frames
+	q.frames = make([]outbound, 0, q.capacity)
+	return frames
+}
@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"testing"
+
+	"stock-market-websocket/internal/wire"
+)
+
+func TestSendQueue_DropsOldestLiveUpdate(t *testing.T) {
+	q := newSendQueue(3)
+	q.push(outbound{data: []byte("live-1"), droppable: true})
+	q.push(outbound{data: []byte("closed-1")})
+	q.push(outbound{data: []byte("live-2"), droppable: true})
+
+	dropped, overflowed, _ := q.push(outbound{data: []byte("live-3"), droppable: true})
+	if dropped != 1 || !overflowed {
+		t.Fatalf("Expected one dropped frame on overflow, got %d (overflowed %t)", dropped, overflowed)
+	}
+
+	// Closed candles push out live updates but never each other
+	q.push(outbound{data: []byte("live-4"), droppable: true})
+	q.push(outbound{data: []byte("closed-2")})
+	q.push(outbound{data: []byte("closed-3")})
+	if _, overflowed, refused := q.push(outbound{data: []byte("closed-4")}); !overflowed || !refused {
+		t.Fatalf("Expected a closed candle to be refused by a queue full of closed candles, got overflowed %t, refused %t", overflowed, refused)
+	}
+
+	var got []string
+	for _, frame := range q.drain() {
+		got = append(got, string(frame.data))
+	}
+	want := []string{"closed-1", "closed-2", "closed-3"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestClient_DisconnectsAfterRepeatedOverflows(t *testing.T) {
+	cm := NewClientManager(testConfig(), []string{"AAPL"}, nil)
+
+	// No writer runs for this client, so its queue never drains
+	c := &client{
+		conn:    dialTestServer(t, cm),
+		manager: cm,
+		queue:   newSendQueue(cm.sendQueueSize),
+		done:    make(chan struct{}),
+	}
+	for range cm.sendQueueSize + cm.maxOverflows {
+		c.enqueue([]byte("{}"), true)
+	}
+	select {
+	case <-c.done:
+		t.Fatal("Expected client to stay connected within the overflow limit")
+	default:
+	}
+
+	c.enqueue([]byte("{}"), true)
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("Expected slow client to be disconnected")
+	}
+	if stats := cm.Stats(); stats.Disconnects[DisconnectSlowConsumer] != 1 {
+		t.Errorf("Expected one slow disconnect, got %+v", stats)
+	}
+}
+
+func TestClient_ForgivesOverflowsOnceCaughtUp(t *testing.T) {
+	cm := NewClientManager(testConfig(), []string{"AAPL"}, nil)
+
+	// Frames are only written when the test writes the queue
+	c := &client{
+		conn:    dialTestServer(t, cm),
+		codec:   wire.JSON,
+		manager: cm,
+		queue:   newSendQueue(cm.sendQueueSize),
+		done:    make(chan struct{}),
+	}
+	burst := func() {
+		c.stateMutex.Lock()
+		defer c.stateMutex.Unlock()
+		for range cm.sendQueueSize + cm.maxOverflows {
+			c.enqueue([]byte("{}"), true)
+		}
+	}
+
+	// Each burst overflows up to the limit, and the client recovers between
+	for range 3 {
+		burst()
+		if err := c.writeQueued(); err != nil {
+			t.Fatalf("Failed to write queued frames: %v", err)
+		}
+	}
+	select {
+	case <-c.done:
+		t.Fatal("Expected a client that caught up between bursts to stay connected")
+	default:
+	}
+
+	burst()
+	c.stateMutex.Lock()
+	c.enqueue([]byte("{}"), true)
+	c.stateMutex.Unlock()
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("Expected a client that does not catch up to be disconnected")
+	}
+}
+
+func TestClient_DisconnectsWhenClosedCandlesFillQueue(t *testing.T) {
+	cm := NewClientManager(testConfig(), []string{"AAPL"}, nil)
+
+	// No writer runs for this client, so its queue never drains
+	c := &client{
+		conn:    dialTestServer(t, cm),
+		manager: cm,
+		queue:   newSendQueue(cm.sendQueueSize),
+		done:    make(chan struct{}),
+	}
+	for range cm.sendQueueSize {
+		c.enqueue([]byte("{}"), false)
+	}
+	select {
+	case <-c.done:
+		t.Fatal("Expected client to stay connected while its queue has room")
+	default:
+	}
+
+	// The queue neither grows past its capacity nor drops a closed candle
+	c.enqueue([]byte("{}"), false)
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("Expected the client to be disconnected")
+	}
+	if n := c.queue.len(); n != cm.sendQueueSize {
+		t.Errorf("Expected the queue to stay at %d frames, got %d", cm.sendQueueSize, n)
+	}
+	if stats := cm.Stats(); stats.Disconnects[DisconnectSlowConsumer] != 1 {
+		t.Errorf("Expected one slow disconnect, got %+v", stats)
+	}
+}
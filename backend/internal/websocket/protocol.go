@@ -0,0 +1,275 @@
+package websocket
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"stock-market-websocket/internal/models"
+)
+
+// handleFrame applies a client frame. JSON frames use the subscription
+// protocol; any other text is treated as the legacy "SYMBOL" or
+// "SYMBOL:INTERVAL" frame that replaces the client's only subscription.
+// The returned error is only set when replying to the client fails.
+func (cm *ClientManager) handleFrame(c *client, frame []byte) error {
+	frame = bytes.TrimSpace(frame)
+	if len(frame) == 0 || frame[0] != '{' {
+		cm.handleLegacyFrame(c, string(frame))
+		return nil
+	}
+
+	var request models.ClientRequest
+	if err := json.Unmarshal(frame, &request); err != nil {
+		return c.send(errorMessage("", fmt.Errorf("invalid request: %w", err)))
+	}
+
+	switch request.Action {
+	case models.ActionSubscribe:
+		return cm.handleSubscribe(c, &request)
+	case models.ActionUnsubscribe:
+		return cm.handleUnsubscribe(c, &request)
+	case models.ActionResume:
+		return cm.handleResume(c, &request)
+	case models.ActionList:
+		return c.send(&models.ControlMessage{
+			Type:          models.ControlAck,
+			Action:        models.ActionList,
+			Subscriptions: cm.listSubscriptions(c),
+		})
+	default:
+		return c.send(errorMessage(request.Action, fmt.Errorf("unknown action %q", request.Action)))
+	}
+}
+
+// handleSubscribe adds subscriptions for the requested symbols, sending a
+// snapshot of each
+func (cm *ClientManager) handleSubscribe(c *client, request *models.ClientRequest) error {
+	interval, err := requestInterval(request)
+	if err != nil {
+		return c.send(errorMessage(request.Action, err))
+	}
+	if err := cm.validateSymbols(request.Symbols); err != nil {
+		return c.send(errorMessage(request.Action, err))
+	}
+
+	return cm.subscribe(c, request.Action, request.Symbols, interval, func(key models.Subscription) (uint64, error) {
+		return cm.sendSnapshot(c, key, false)
+	})
+}
+
+// handleResume subscribes to the requested symbols and replays the updates
+// missed since the given seq of each symbol, or the closed candles since the
+// given time. Symbols that cannot be replayed get a reset snapshot.
+func (cm *ClientManager) handleResume(c *client, request *models.ClientRequest) error {
+	interval, err := requestInterval(request)
+	if err != nil {
+		return c.send(errorMessage(request.Action, err))
+	}
+	if len(request.Seqs) == 0 && request.Since <= 0 {
+		return c.send(errorMessage(request.Action, fmt.Errorf("seqs or since is required")))
+	}
+
+	symbols := slices.Clone(request.Symbols)
+	for symbol := range request.Seqs {
+		if !slices.Contains(symbols, symbol) {
+			symbols = append(symbols, symbol)
+		}
+	}
+	slices.Sort(symbols)
+	if err := cm.validateSymbols(symbols); err != nil {
+		return c.send(errorMessage(request.Action, err))
+	}
+
+	return cm.subscribe(c, request.Action, symbols, interval, func(key models.Subscription) (uint64, error) {
+		return cm.sendReplay(c, key, request)
+	})
+}
+
+// subscribe acknowledges new subscriptions, then sends the initial state of
+// each before activating it. Updates are buffered until then so none are
+// lost or reordered.
+func (cm *ClientManager) subscribe(c *client, action string, symbols []string, interval models.Interval, sendInitial func(key models.Subscription) (uint64, error)) error {
+	c.stateMutex.Lock()
+	for _, symbol := range symbols {
+		key := models.Subscription{Symbol: symbol, Interval: interval}
+		delete(c.subscriptions, key)
+		c.pending[key] = nil
+	}
+	c.stateMutex.Unlock()
+
+	log.Printf("Client subscribed: %s (%s)", strings.Join(symbols, ","), interval)
+	if err := c.send(&models.ControlMessage{
+		Type:     models.ControlAck,
+		Action:   action,
+		Symbols:  symbols,
+		Interval: interval,
+	}); err != nil {
+		return err
+	}
+
+	for _, symbol := range symbols {
+		key := models.Subscription{Symbol: symbol, Interval: interval}
+		seq, err := sendInitial(key)
+		if err != nil {
+			return err
+		}
+		if err := c.activate(key, seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendSnapshot writes the snapshot of a subscription and returns its seq
+func (cm *ClientManager) sendSnapshot(c *client, key models.Subscription, reset bool) (uint64, error) {
+	if cm.candles == nil {
+		return 0, nil
+	}
+
+	snapshot, err := cm.candles.Snapshot(key.Symbol, key.Interval, cm.snapshotSize)
+	if err != nil {
+		log.Printf("Failed to build snapshot for %s (%s): %v", key.Symbol, key.Interval, err)
+		return 0, c.send(errorMessage(models.ActionSubscribe, fmt.Errorf("snapshot unavailable for %s", key.Symbol)))
+	}
+	snapshot.Reset = reset
+	return snapshot.Seq, c.send(snapshot)
+}
+
+// sendReplay writes the updates a resuming client missed and returns the seq
+// they reflect, falling back to a reset snapshot
+func (cm *ClientManager) sendReplay(c *client, key models.Subscription, request *models.ClientRequest) (uint64, error) {
+	if cm.candles == nil {
+		return cm.sendSnapshot(c, key, true)
+	}
+
+	var (
+		updates []models.BroadcastMessage
+		seq     uint64
+		ok      bool
+	)
+	if lastSeq, found := request.Seqs[key.Symbol]; found {
+		updates, seq, ok = cm.candles.Replay(key.Symbol, key.Interval, request.Epoch, lastSeq)
+	} else {
+		var err error
+		updates, seq, ok, err = cm.candles.ReplaySince(key.Symbol, key.Interval, time.UnixMilli(request.Since), maxResumeCandles)
+		if err != nil {
+			log.Printf("Failed to replay %s (%s): %v", key.Symbol, key.Interval, err)
+		}
+	}
+	if !ok {
+		return cm.sendSnapshot(c, key, true)
+	}
+
+	for i := range updates {
+		if err := c.send(&updates[i]); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// handleUnsubscribe removes subscriptions for the requested symbols. Without
+// an interval every interval of the symbols is removed.
+func (cm *ClientManager) handleUnsubscribe(c *client, request *models.ClientRequest) error {
+	if request.Interval != "" {
+		if _, err := models.ParseInterval(string(request.Interval)); err != nil {
+			return c.send(errorMessage(request.Action, err))
+		}
+	}
+	if len(request.Symbols) == 0 {
+		return c.send(errorMessage(request.Action, fmt.Errorf("symbols are required")))
+	}
+
+	matches := func(sub models.Subscription) bool {
+		return slices.Contains(request.Symbols, sub.Symbol) && (request.Interval == "" || request.Interval == sub.Interval)
+	}
+	c.stateMutex.Lock()
+	maps.DeleteFunc(c.subscriptions, func(sub models.Subscription, _ bool) bool { return matches(sub) })
+	maps.DeleteFunc(c.pending, func(sub models.Subscription, _ []*models.BroadcastMessage) bool { return matches(sub) })
+	c.stateMutex.Unlock()
+
+	return c.send(&models.ControlMessage{
+		Type:     models.ControlAck,
+		Action:   request.Action,
+		Symbols:  request.Symbols,
+		Interval: request.Interval,
+	})
+}
+
+// handleLegacyFrame replaces the client's subscriptions with a single one
+func (cm *ClientManager) handleLegacyFrame(c *client, frame string) {
+	symbol, name, found := strings.Cut(frame, ":")
+	interval := models.Interval1m
+	if found {
+		parsed, err := models.ParseInterval(name)
+		if err != nil {
+			log.Printf("Ignoring invalid subscription %q: %v", frame, err)
+			return
+		}
+		interval = parsed
+	}
+
+	c.stateMutex.Lock()
+	c.subscriptions = map[models.Subscription]bool{
+		{Symbol: symbol, Interval: interval}: true,
+	}
+	clear(c.pending)
+	c.stateMutex.Unlock()
+
+	log.Printf("Client connected: %s (%s)", symbol, interval)
+}
+
+// listSubscriptions returns the client's subscriptions in a stable order
+func (cm *ClientManager) listSubscriptions(c *client) []models.Subscription {
+	c.stateMutex.Lock()
+	subscriptions := make([]models.Subscription, 0, len(c.subscriptions)+len(c.pending))
+	for sub := range c.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	for sub := range c.pending {
+		subscriptions = append(subscriptions, sub)
+	}
+	c.stateMutex.Unlock()
+
+	slices.SortFunc(subscriptions, func(a, b models.Subscription) int {
+		return cmp.Or(cmp.Compare(a.Symbol, b.Symbol), cmp.Compare(a.Interval, b.Interval))
+	})
+	return subscriptions
+}
+
+// validateSymbols checks that every symbol is tracked by the server
+func (cm *ClientManager) validateSymbols(symbols []string) error {
+	if len(symbols) == 0 {
+		return fmt.Errorf("symbols are required")
+	}
+	for _, symbol := range symbols {
+		if !cm.symbols[symbol] {
+			return fmt.Errorf("unknown symbol %q", symbol)
+		}
+	}
+	return nil
+}
+
+// requestInterval returns the requested interval, defaulting to one minute
+func requestInterval(request *models.ClientRequest) (models.Interval, error) {
+	if request.Interval == "" {
+		return models.Interval1m, nil
+	}
+	return models.ParseInterval(string(request.Interval))
+}
+
+// errorMessage builds an error frame for an action
+func errorMessage(action string, err error) *models.ControlMessage {
+	return &models.ControlMessage{
+		Type:   models.ControlError,
+		Action: action,
+		Error:  err.Error(),
+	}
+}
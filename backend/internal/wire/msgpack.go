@@ -0,0 +1,26 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackCodec encodes messages as MessagePack maps with the same keys as
+// the JSON format
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string     { return "msgpack" }
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
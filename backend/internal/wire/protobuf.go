@@ -0,0 +1,100 @@
+package wire
+
+import (
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/wire/streampb"
+)
+
+//go:generate protoc -I ../../proto --go_out=streampb --go_opt=paths=source_relative stream.proto
+
+// protobufCodec encodes messages as the ServerMessage type of
+// proto/stream.proto
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string     { return "protobuf" }
+func (protobufCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (protobufCodec) Encode(v interface{}) ([]byte, error) {
+	var msg streampb.ServerMessage
+	switch v := v.(type) {
+	case *models.BroadcastMessage:
+		msg.Message = &streampb.ServerMessage_Update{Update: toUpdate(v)}
+	case *models.Snapshot:
+		msg.Message = &streampb.ServerMessage_Snapshot{Snapshot: toSnapshot(v)}
+	case *models.ControlMessage:
+		msg.Message = &streampb.ServerMessage_Control{Control: toControl(v)}
+	default:
+		return nil, fmt.Errorf("protobuf: unsupported message type %T", v)
+	}
+	return proto.Marshal(&msg)
+}
+
+// toUpdate converts a broadcast update to a CandleUpdate
+func toUpdate(msg *models.BroadcastMessage) *streampb.CandleUpdate {
+	return &streampb.CandleUpdate{
+		UpdateType: string(msg.UpdateType),
+		Candle:     toCandle(msg.Candle),
+		Seq:        msg.Seq,
+		Epoch:      msg.Epoch,
+	}
+}
+
+// toSnapshot converts a snapshot to its protobuf message
+func toSnapshot(snapshot *models.Snapshot) *streampb.Snapshot {
+	candles := make([]*streampb.Candle, len(snapshot.Candles))
+	for i := range snapshot.Candles {
+		candles[i] = toCandle(&snapshot.Candles[i])
+	}
+	return &streampb.Snapshot{
+		Symbol:   snapshot.Symbol,
+		Interval: string(snapshot.Interval),
+		Seq:      snapshot.Seq,
+		Candles:  candles,
+		Live:     toCandle(snapshot.Live),
+		Reset_:   snapshot.Reset,
+		Epoch:    snapshot.Epoch,
+	}
+}
+
+// toControl converts a control message to its protobuf message
+func toControl(msg *models.ControlMessage) *streampb.Control {
+	subscriptions := make([]*streampb.Subscription, len(msg.Subscriptions))
+	for i, sub := range msg.Subscriptions {
+		subscriptions[i] = &streampb.Subscription{Symbol: sub.Symbol, Interval: string(sub.Interval)}
+	}
+	return &streampb.Control{
+		Type:          msg.Type,
+		Action:        msg.Action,
+		Symbols:       msg.Symbols,
+		Interval:      string(msg.Interval),
+		Subscriptions: subscriptions,
+		Error:         msg.Error,
+	}
+}
+
+// toCandle converts a candle to its protobuf message, or nil
+func toCandle(candle *models.Candle) *streampb.Candle {
+	if candle == nil {
+		return nil
+	}
+	var timestamp int64
+	if !candle.Timestamp.IsZero() {
+		timestamp = candle.Timestamp.UnixMilli()
+	}
+	return &streampb.Candle{
+		Symbol:      candle.Symbol,
+		Interval:    string(candle.Interval),
+		TimestampMs: timestamp,
+		Open:        candle.Open,
+		High:        candle.High,
+		Low:         candle.Low,
+		Close:       candle.Close,
+		Volume:      candle.Volume,
+		Id:          uint64(candle.ID),
+	}
+}
@@ -0,0 +1,56 @@
+package wire
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec encodes server messages in one wire format
+type Codec interface {
+	// Name is the WebSocket subprotocol that selects the codec
+	Name() string
+	// MessageType is the WebSocket frame type used for encoded messages
+	MessageType() int
+	// Encode encodes a broadcast update, snapshot or control message
+	Encode(v interface{}) ([]byte, error)
+}
+
+// Codecs in order of server preference during subprotocol negotiation
+var (
+	JSON     Codec = jsonCodec{}
+	Msgpack  Codec = msgpackCodec{}
+	Protobuf Codec = protobufCodec{}
+
+	codecs = []Codec{Protobuf, Msgpack, JSON}
+)
+
+// Subprotocols returns the subprotocol names clients may request
+func Subprotocols() []string {
+	names := make([]string, 0, len(codecs))
+	for _, codec := range codecs {
+		names = append(names, codec.Name())
+	}
+	return names
+}
+
+// ForSubprotocol returns the codec of a negotiated subprotocol, defaulting to
+// JSON when none was negotiated
+func ForSubprotocol(name string) Codec {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec
+		}
+	}
+	return JSON
+}
+
+// jsonCodec encodes messages as JSON text frames
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string     { return "json" }
+func (jsonCodec) MessageType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
@@ -0,0 +1,174 @@
+package wire
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"stock-market-websocket/internal/models"
+	"stock-market-websocket/internal/wire/streampb"
+)
+
+func testUpdate() *models.BroadcastMessage {
+	return &models.BroadcastMessage{
+		UpdateType: models.Live,
+		Seq:        42,
+		Epoch:      7,
+		Candle: &models.Candle{
+			Symbol:    "AAPL",
+			Interval:  models.Interval1m,
+			Open:      190.5,
+			High:      191,
+			Low:       190,
+			Close:     190.75,
+			Volume:    1200,
+			Timestamp: time.Date(2024, 6, 3, 13, 30, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestForSubprotocol(t *testing.T) {
+	for name, want := range map[string]Codec{"": JSON, "json": JSON, "msgpack": Msgpack, "protobuf": Protobuf, "xml": JSON} {
+		if got := ForSubprotocol(name); got != want {
+			t.Errorf("ForSubprotocol(%q) = %s, want %s", name, got.Name(), want.Name())
+		}
+	}
+}
+
+func TestMsgpack_UsesJSONKeys(t *testing.T) {
+	data, err := Msgpack.Encode(testUpdate())
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := msgpack.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	candle, _ := decoded["candle"].(map[string]interface{})
+	if decoded["update_type"] != "live" || candle["symbol"] != "AAPL" || candle["close"] != 190.75 {
+		t.Errorf("Unexpected message: %v", decoded)
+	}
+}
+
+// consumeFields decodes the top-level fields of a protobuf message, keeping
+// the last value of each field number
+func consumeFields(t *testing.T, b []byte) map[protowire.Number]interface{} {
+	t.Helper()
+
+	fields := make(map[protowire.Number]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("Invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			fields[num], n = v, m
+		case protowire.Fixed64Type:
+			v, m := protowire.ConsumeFixed64(b)
+			fields[num], n = math.Float64frombits(v), m
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(b)
+			fields[num], n = v, m
+		default:
+			t.Fatalf("Unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatalf("Invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return fields
+}
+
+func TestProtobuf_EncodesCandleUpdate(t *testing.T) {
+	msg := testUpdate()
+	data, err := Protobuf.Encode(msg)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	envelope := consumeFields(t, data)
+	// ServerMessage.update is field 1
+	update := consumeFields(t, envelope[1].([]byte))
+	if string(update[1].([]byte)) != "live" || update[3] != uint64(42) || update[4] != uint64(7) {
+		t.Errorf("Unexpected update fields: %v", update)
+	}
+
+	candle := consumeFields(t, update[2].([]byte))
+	if string(candle[1].([]byte)) != "AAPL" || string(candle[2].([]byte)) != "1m" {
+		t.Errorf("Unexpected candle identity: %v", candle)
+	}
+	if candle[3] != uint64(msg.Candle.Timestamp.UnixMilli()) || candle[7] != 190.75 || candle[8] != uint64(1200) {
+		t.Errorf("Unexpected candle values: %v", candle)
+	}
+}
+
+// decodeServerMessage decodes an encoded frame with the types generated from
+// proto/stream.proto
+func decodeServerMessage(t *testing.T, v interface{}) *streampb.ServerMessage {
+	t.Helper()
+
+	data, err := Protobuf.Encode(v)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	var msg streampb.ServerMessage
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("Failed to decode with the generated types: %v", err)
+	}
+	return &msg
+}
+
+func TestProtobuf_DecodesWithGeneratedTypes(t *testing.T) {
+	msg := testUpdate()
+	update := decodeServerMessage(t, msg).GetUpdate()
+	candle := update.GetCandle()
+	if update.GetUpdateType() != "live" || update.GetSeq() != 42 || update.GetEpoch() != 7 {
+		t.Errorf("Unexpected update: %v", update)
+	}
+	if candle.GetSymbol() != "AAPL" || candle.GetInterval() != "1m" || candle.GetTimestampMs() != msg.Candle.Timestamp.UnixMilli() ||
+		candle.GetOpen() != 190.5 || candle.GetHigh() != 191 || candle.GetLow() != 190 || candle.GetClose() != 190.75 || candle.GetVolume() != 1200 {
+		t.Errorf("Unexpected candle: %v", candle)
+	}
+
+	snapshot := decodeServerMessage(t, &models.Snapshot{
+		Symbol:   "AAPL",
+		Interval: models.Interval1m,
+		Seq:      41,
+		Epoch:    7,
+		Reset:    true,
+		Candles:  []models.Candle{{ID: 3, Symbol: "AAPL", Close: 1}, {ID: 4, Symbol: "AAPL", Close: 2}},
+		Live:     msg.Candle,
+	}).GetSnapshot()
+	if snapshot.GetSymbol() != "AAPL" || snapshot.GetSeq() != 41 || snapshot.GetEpoch() != 7 || !snapshot.GetReset_() ||
+		len(snapshot.GetCandles()) != 2 || snapshot.GetCandles()[1].GetId() != 4 || snapshot.GetLive().GetClose() != 190.75 {
+		t.Errorf("Unexpected snapshot: %v", snapshot)
+	}
+
+	control := decodeServerMessage(t, &models.ControlMessage{
+		Type:          models.ControlAck,
+		Action:        "subscribe",
+		Symbols:       []string{"AAPL", "MSFT"},
+		Interval:      models.Interval5m,
+		Subscriptions: []models.Subscription{{Symbol: "AAPL", Interval: models.Interval5m}},
+	}).GetControl()
+	if control.GetType() != "ack" || control.GetAction() != "subscribe" || len(control.GetSymbols()) != 2 || control.GetInterval() != "5m" ||
+		len(control.GetSubscriptions()) != 1 || control.GetSubscriptions()[0].GetSymbol() != "AAPL" {
+		t.Errorf("Unexpected control message: %v", control)
+	}
+}
+
+func TestProtobuf_RejectsUnknownMessages(t *testing.T) {
+	if _, err := Protobuf.Encode("hello"); err == nil {
+		t.Error("Expected an error for an unsupported message")
+	}
+}
@@ -16,7 +16,7 @@ echo "✅ Backups created in backup/ directory"
 
 # Test the new structure
 echo "🧪 Testing new structure..."
-if go run cmd/main.go --help 2>/dev/null; then
+if go run ./cmd --help 2>/dev/null; then
     echo "✅ New structure compiles successfully"
 else
     echo "⚠️  New structure may have issues, but this is expected if no .env file exists"
@@ -39,7 +39,7 @@ echo "  │   └── broadcaster/         # Real-time broadcasting"
 echo "  └── backup/                  # Original files backup"
 echo ""
 echo "🚀 To run the new structure:"
-echo "  go run cmd/main.go"
+echo "  go run ./cmd"
 echo ""
 echo "🐳 To build with Docker:"
 echo "  docker build -f Dockerfile.prod -t stock-market-backend ."